@@ -0,0 +1,249 @@
+package web
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// A Principal is the authenticated client of a request, as set by the
+// authentication middleware.
+type Principal struct {
+	Name string
+	// Scheme is the authentication scheme, such as "Basic" or "Bearer".
+	Scheme string
+	Roles  []string
+	// Data carries application specific information, such as a user record.
+	Data interface{}
+}
+
+// Principal returns the authenticated client of the request, or nil.
+func (ctx *Context) Principal() *Principal {
+	return ctx.principal
+}
+
+// SetPrincipal sets the authenticated client of the request. It is used by
+// the authentication middleware.
+func (ctx *Context) SetPrincipal(p *Principal) {
+	ctx.principal = p
+}
+
+// CredentialsFunc validates a user name and password, returning the
+// principal they belong to or nil if they are invalid.
+type CredentialsFunc func(user string, password string) *Principal
+
+// TokenFunc validates a bearer token or API key, returning the principal it
+// belongs to or nil if it is invalid.
+type TokenFunc func(token string) *Principal
+
+// BasicAuth returns middleware that requires HTTP Basic authentication.
+// Requests without valid credentials are answered with 401 and a challenge
+// for realm. If an earlier middleware already authenticated the request,
+// it is passed on unchanged.
+func BasicAuth(realm string, check CredentialsFunc) Middleware {
+	challenge := `Basic realm=` + quoteAuthParam(realm) + `, charset="UTF-8"`
+	return func(ctx *Context, next func()) {
+		if ctx.principal == nil {
+			user, password, err := ctx.GetBasicAuth()
+			var p *Principal
+			if err == nil {
+				p = check(user, password)
+			}
+			if p == nil {
+				ctx.unauthorized(challenge)
+				return
+			}
+			ctx.principal = withScheme(p, "Basic")
+		}
+		next()
+	}
+}
+
+// BearerAuth returns middleware that requires a bearer token in the
+// Authorization header, as described in RFC 6750.
+func BearerAuth(realm string, check TokenFunc) Middleware {
+	challenge := `Bearer realm=` + quoteAuthParam(realm)
+	return func(ctx *Context, next func()) {
+		if ctx.principal == nil {
+			token, ok := ctx.bearerToken()
+			if !ok {
+				ctx.unauthorized(challenge)
+				return
+			}
+			p := check(token)
+			if p == nil {
+				ctx.unauthorized(challenge + `, error="invalid_token"`)
+				return
+			}
+			ctx.principal = withScheme(p, "Bearer")
+		}
+		next()
+	}
+}
+
+// APIKeyConfig configures the APIKeyAuth middleware.
+type APIKeyConfig struct {
+	// Header is the request header holding the key. It defaults to
+	// "X-API-Key".
+	Header string
+	// Param is the request parameter holding the key if the header is not
+	// present. If it is empty, only the header is checked.
+	Param string
+	Realm string
+	Check TokenFunc
+}
+
+// APIKeyAuth returns middleware that requires an API key in a request
+// header or parameter.
+func APIKeyAuth(config APIKeyConfig) Middleware {
+	if config.Header == "" {
+		config.Header = "X-API-Key"
+	}
+	challenge := `APIKey realm=` + quoteAuthParam(config.Realm) + `, header=` + quoteAuthParam(config.Header)
+	return func(ctx *Context, next func()) {
+		if ctx.principal == nil {
+			key := ctx.Request.Header.Get(config.Header)
+			if key == "" && config.Param != "" {
+				key = ctx.Params[config.Param]
+			}
+			var p *Principal
+			if key != "" {
+				p = config.Check(key)
+			}
+			if p == nil {
+				ctx.unauthorized(challenge)
+				return
+			}
+			ctx.principal = withScheme(p, "APIKey")
+		}
+		next()
+	}
+}
+
+// withScheme returns p with its Scheme defaulting to scheme. p is copied
+// instead of changed, as it may be shared between requests.
+func withScheme(p *Principal, scheme string) *Principal {
+	if p.Scheme != "" {
+		return p
+	}
+	authenticated := *p
+	authenticated.Scheme = scheme
+	return &authenticated
+}
+
+// unauthorized sends a 401 response with the given challenge.
+func (ctx *Context) unauthorized(challenge string) {
+	ctx.SetHeader("WWW-Authenticate", challenge, true)
+	ctx.Error(401, "Unauthorized")
+}
+
+// bearerToken returns the token of a "Bearer" Authorization header.
+func (ctx *Context) bearerToken() (string, bool) {
+	parts := strings.SplitN(ctx.Request.Header.Get("Authorization"), " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+		return "", false
+	}
+	token := strings.TrimSpace(parts[1])
+	return token, token != ""
+}
+
+func quoteAuthParam(s string) string {
+	return `"` + strings.Replace(strings.Replace(s, `\`, `\\`, -1), `"`, `\"`, -1) + `"`
+}
+
+// Htpasswd holds the users of an Apache htpasswd file. Passwords hashed
+// with bcrypt ("$2y$") and SHA-1 ("{SHA}") are supported.
+type Htpasswd struct {
+	users map[string]string
+	// dummy is checked for unknown users, so that they take as long as
+	// known ones and do not reveal which users exist
+	dummy string
+}
+
+// LoadHtpasswd reads the htpasswd file at path.
+func LoadHtpasswd(path string) (*Htpasswd, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := &Htpasswd{users: map[string]string{}}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			return nil, errors.New("Malformed htpasswd line: " + line)
+		}
+		hash := parts[1]
+		if !strings.HasPrefix(hash, "$2") && !strings.HasPrefix(hash, "{SHA}") {
+			return nil, errors.New("Unsupported password hash for htpasswd user " + parts[0])
+		}
+		h.users[parts[0]] = hash
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	h.dummy, err = h.dummyHash()
+	return h, err
+}
+
+// dummyHash returns a hash of the same kind and cost as the hashes of the
+// users.
+func (h *Htpasswd) dummyHash() (string, error) {
+	for _, hash := range h.users {
+		if cost, err := bcrypt.Cost([]byte(hash)); err == nil {
+			dummy, err := bcrypt.GenerateFromPassword([]byte("dummy"), cost)
+			return string(dummy), err
+		}
+	}
+	return "{SHA}", nil
+}
+
+// Authenticate is a CredentialsFunc that checks the password of user
+// against the htpasswd file.
+func (h *Htpasswd) Authenticate(user string, password string) *Principal {
+	hash, ok := h.users[user]
+	if !ok {
+		checkPassword(h.dummy, password)
+		return nil
+	}
+	if !checkPassword(hash, password) {
+		return nil
+	}
+	return &Principal{Name: user}
+}
+
+// checkPassword reports whether password matches an htpasswd hash.
+func checkPassword(hash string, password string) bool {
+	if strings.HasPrefix(hash, "{SHA}") {
+		sum := sha1.Sum([]byte(password))
+		expected := "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+		return subtle.ConstantTimeCompare([]byte(hash), []byte(expected)) == 1
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+var principalType = reflect.TypeOf(&Principal{})
+
+// getPrincipal injects the authenticated *Principal into handlers.
+func getPrincipal(t reflect.Type, values []string, valueIndex int, ctx *Context) (reflect.Value, error) {
+	if t != principalType {
+		return reflect.Value{}, NotSupported
+	}
+	if ctx == nil {
+		return reflect.Zero(t), NoValueNeeded
+	}
+	return reflect.ValueOf(ctx.principal), NoValueNeeded
+}
@@ -0,0 +1,115 @@
+package web
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"io/ioutil"
+	"log"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func authRequest(s *Server, path string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", path, nil)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	s.Process(rec, req)
+	return rec
+}
+
+func TestBasicAuthHtpasswd(t *testing.T) {
+	bcryptHash, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	sum := sha1.Sum([]byte("p:ss"))
+	content := "# users\nalice:" + string(bcryptHash) + "\nbob:{SHA}" + base64.StdEncoding.EncodeToString(sum[:]) + "\n"
+	dir, err := ioutil.TempDir("", "htpasswd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "htpasswd")
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	htpasswd, err := LoadHtpasswd(path)
+	if err != nil {
+		t.Fatalf("Failed to load htpasswd: %v", err)
+	}
+	// unknown users are checked against a hash as slow as the known ones
+	if cost, err := bcrypt.Cost([]byte(htpasswd.dummy)); err != nil || cost != bcrypt.MinCost {
+		t.Fatalf("Expected a dummy hash with cost %d, got %d %v", bcrypt.MinCost, cost, err)
+	}
+
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	s.Use(BasicAuth("admin area", htpasswd.Authenticate))
+	s.Get("/", func(p *Principal) string { return p.Name + " " + p.Scheme })
+
+	tests := []struct {
+		user, password string
+		status         int
+		body           string
+	}{
+		{"alice", "secret", 200, "alice Basic"},
+		{"bob", "p:ss", 200, "bob Basic"},
+		{"alice", "wrong", 401, "Unauthorized"},
+		{"carol", "secret", 401, "Unauthorized"},
+	}
+	for _, test := range tests {
+		rec := authRequest(s, "/", map[string]string{"Authorization": BuildBasicAuthCredentials(test.user, test.password)})
+		if rec.Code != test.status || rec.Body.String() != test.body {
+			t.Fatalf("%s: expected %d %q, got %d %q", test.user, test.status, test.body, rec.Code, rec.Body.String())
+		}
+	}
+
+	rec := authRequest(s, "/", nil)
+	if rec.Code != 401 || rec.Header().Get("WWW-Authenticate") != `Basic realm="admin area", charset="UTF-8"` {
+		t.Fatalf("Expected a Basic challenge, got %d %q", rec.Code, rec.Header().Get("WWW-Authenticate"))
+	}
+
+	ioutil.WriteFile(path, []byte("eve:$apr1$abc$def\n"), 0600)
+	if _, err := LoadHtpasswd(path); err == nil {
+		t.Fatalf("Expected unsupported hashes to be reported")
+	}
+}
+
+func TestBearerAndAPIKeyAuth(t *testing.T) {
+	// the principal is shared between requests, so it must not be changed
+	shared := &Principal{Name: "client"}
+	check := func(token string) *Principal {
+		if token == "valid" {
+			return shared
+		}
+		return nil
+	}
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	s.Get("/bearer", func(ctx *Context) string { return ctx.Principal().Scheme }).Use(BearerAuth("api", check))
+	s.Get("/apikey", func(ctx *Context) string { return ctx.Principal().Scheme }).Use(APIKeyAuth(APIKeyConfig{Param: "key", Check: check}))
+
+	if rec := authRequest(s, "/bearer", map[string]string{"Authorization": "Bearer valid"}); rec.Code != 200 || rec.Body.String() != "Bearer" {
+		t.Fatalf("Expected a valid bearer token to be accepted, got %d", rec.Code)
+	}
+	rec := authRequest(s, "/bearer", map[string]string{"Authorization": "Bearer invalid"})
+	if rec.Code != 401 || rec.Header().Get("WWW-Authenticate") != `Bearer realm="api", error="invalid_token"` {
+		t.Fatalf("Expected an invalid_token challenge, got %d %q", rec.Code, rec.Header().Get("WWW-Authenticate"))
+	}
+
+	if rec := authRequest(s, "/apikey", map[string]string{"X-API-Key": "valid"}); rec.Code != 200 || rec.Body.String() != "APIKey" {
+		t.Fatalf("Expected an API key in the header to be accepted, got %d", rec.Code)
+	}
+	if rec := authRequest(s, "/apikey?key=valid", nil); rec.Code != 200 {
+		t.Fatalf("Expected an API key in the query to be accepted, got %d", rec.Code)
+	}
+	if rec := authRequest(s, "/apikey?key=invalid", nil); rec.Code != 401 {
+		t.Fatalf("Expected an invalid API key to be rejected, got %d", rec.Code)
+	}
+	if shared.Scheme != "" {
+		t.Fatalf("Expected the principal of the check to be copied, got scheme %q", shared.Scheme)
+	}
+}
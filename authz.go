@@ -0,0 +1,124 @@
+package web
+
+import "strings"
+
+// A requirement is an authorization condition attached to routes.
+type requirement struct {
+	description string
+	allowed     func(ctx *Context) bool
+}
+
+// RouteInfo describes a registered route, for example for a security
+// review of which routes require what.
+type RouteInfo struct {
+	Method       string
+	Path         string
+	Requirements []string
+}
+
+// HasRole reports whether p has the given role.
+func (p *Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+func authenticated(ctx *Context) bool {
+	return true
+}
+
+func roleRequirement(roles []string) requirement {
+	return requirement{
+		description: "role " + strings.Join(roles, "|"),
+		allowed: func(ctx *Context) bool {
+			for _, role := range roles {
+				if ctx.principal.HasRole(role) {
+					return true
+				}
+			}
+			return false
+		},
+	}
+}
+
+// RequireAuth requires an authenticated principal for route r.
+// Authentication itself is done by middleware such as BasicAuth or JWTAuth.
+func (r *Route) RequireAuth() *Route {
+	r.requirements = append(r.requirements, requirement{"authenticated", authenticated})
+	return r
+}
+
+// RequireRole requires a principal with at least one of roles for route r.
+func (r *Route) RequireRole(roles ...string) *Route {
+	r.requirements = append(r.requirements, roleRequirement(roles))
+	return r
+}
+
+// Require requires a principal for which allowed returns true for route r.
+// The description is listed by Server.Routes.
+func (r *Route) Require(description string, allowed func(ctx *Context) bool) *Route {
+	r.requirements = append(r.requirements, requirement{description, allowed})
+	return r
+}
+
+// RequireAuth requires an authenticated principal for all routes of g.
+func (g *Group) RequireAuth() {
+	g.requirements = append(g.requirements, requirement{"authenticated", authenticated})
+}
+
+// RequireRole requires a principal with at least one of roles for all
+// routes of g.
+func (g *Group) RequireRole(roles ...string) {
+	g.requirements = append(g.requirements, roleRequirement(roles))
+}
+
+// Require requires a principal for which allowed returns true for all
+// routes of g.
+func (g *Group) Require(description string, allowed func(ctx *Context) bool) {
+	g.requirements = append(g.requirements, requirement{description, allowed})
+}
+
+// allRequirements returns the requirements of route and its groups.
+func (r *Route) allRequirements() []requirement {
+	var requirements []requirement
+	for _, g := range r.groups() {
+		requirements = append(requirements, g.requirements...)
+	}
+	return append(requirements, r.requirements...)
+}
+
+// authorize checks the requirements of the matched route. Requests without
+// a principal are answered with 401, requests of a principal that does not
+// meet a requirement with 403.
+func (ctx *Context) authorize(route *Route) bool {
+	requirements := route.allRequirements()
+	if len(requirements) == 0 {
+		return true
+	}
+	if ctx.principal == nil {
+		ctx.Error(401, "Unauthorized")
+		return false
+	}
+	for _, req := range requirements {
+		if !req.allowed(ctx) {
+			ctx.Error(403, "Forbidden")
+			return false
+		}
+	}
+	return true
+}
+
+// Routes lists the registered routes with their authorization requirements.
+func (s *Server) Routes() []RouteInfo {
+	infos := make([]RouteInfo, len(s.routes))
+	for i, route := range s.routes {
+		infos[i] = RouteInfo{Method: route.method, Path: route.path}
+		for _, req := range route.allRequirements() {
+			infos[i].Requirements = append(infos[i].Requirements, req.description)
+		}
+	}
+	return infos
+}
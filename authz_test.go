@@ -0,0 +1,67 @@
+package web
+
+import (
+	"io/ioutil"
+	"log"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestAuthorization(t *testing.T) {
+	users := map[string]*Principal{
+		"alice": {Name: "alice", Roles: []string{"admin"}},
+		"bob":   {Name: "bob", Roles: []string{"editor"}},
+	}
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	s.Use(func(ctx *Context, next func()) {
+		ctx.SetPrincipal(users[ctx.Request.Header.Get("X-User")])
+		next()
+	})
+	s.Get("/public", func() string { return "public" })
+	s.Get("/profile", func() string { return "profile" }).RequireAuth()
+
+	admin := s.Group("/admin")
+	admin.RequireRole("admin")
+	admin.Get("/users", func() string { return "users" })
+
+	posts := s.Group("/posts")
+	posts.RequireRole("admin", "editor")
+	posts.Delete("/([0-9]+)", func(id string) string { return id }).Require("owner of post", func(ctx *Context) bool {
+		return ctx.Principal().Name == "bob"
+	})
+
+	tests := []struct {
+		method, path, user string
+		status             int
+	}{
+		{"GET", "/public", "", 200},
+		{"GET", "/profile", "", 401},
+		{"GET", "/profile", "bob", 200},
+		{"GET", "/admin/users", "", 401},
+		{"GET", "/admin/users", "bob", 403},
+		{"GET", "/admin/users", "alice", 200},
+		{"DELETE", "/posts/1", "alice", 403},
+		{"DELETE", "/posts/1", "bob", 200},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, test.path, nil)
+		req.Header.Set("X-User", test.user)
+		rec := httptest.NewRecorder()
+		s.Process(rec, req)
+		if rec.Code != test.status {
+			t.Fatalf("%s %s as %q: expected %d, got %d", test.method, test.path, test.user, test.status, rec.Code)
+		}
+	}
+
+	expected := []RouteInfo{
+		{"GET", "/public", nil},
+		{"GET", "/profile", []string{"authenticated"}},
+		{"GET", "/admin/users", []string{"role admin"}},
+		{"DELETE", "/posts/([0-9]+)", []string{"role admin|editor", "owner of post"}},
+	}
+	if routes := s.Routes(); !reflect.DeepEqual(routes, expected) {
+		t.Fatalf("Expected routes %v, got %v", expected, routes)
+	}
+}
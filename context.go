@@ -0,0 +1,171 @@
+package web
+
+import (
+	"context"
+	"reflect"
+	"time"
+)
+
+// Context implements context.Context, so it can be passed to functions
+// that observe deadlines and cancellation, such as database calls. It is
+// done when the client disconnects or the handler timeout expires.
+var _ context.Context = (*Context)(nil)
+
+func (ctx *Context) context() context.Context {
+	ctx.assertLive()
+	if ctx.Request == nil {
+		return context.Background()
+	}
+	return ctx.Request.Context()
+}
+
+// Deadline returns the time when the handler timeout expires, if any.
+func (ctx *Context) Deadline() (time.Time, bool) {
+	return ctx.context().Deadline()
+}
+
+// Done returns a channel that is closed when the request is canceled.
+func (ctx *Context) Done() <-chan struct{} {
+	return ctx.context().Done()
+}
+
+// Err returns why the request was canceled, or nil.
+func (ctx *Context) Err() error {
+	return ctx.context().Err()
+}
+
+// Value returns the value set with Set for a string key, or else the value
+// of the request's context.
+func (ctx *Context) Value(key interface{}) interface{} {
+	if k, ok := key.(string); ok {
+		if value, ok := ctx.values[k]; ok {
+			return value
+		}
+	}
+	return ctx.context().Value(key)
+}
+
+// Set stores a value for the rest of the request, for example to pass data
+// from middleware to handlers.
+func (ctx *Context) Set(key string, value interface{}) {
+	ctx.assertLive()
+	if ctx.values == nil {
+		ctx.values = map[string]interface{}{}
+	}
+	ctx.values[key] = value
+}
+
+// onFinish registers fn to be called when the handler returns, before the
+// Context is reused.
+func (ctx *Context) onFinish(fn func()) {
+	ctx.finishers = append(ctx.finishers, fn)
+}
+
+// runFinishers calls the functions registered with onFinish.
+func (ctx *Context) runFinishers() {
+	for _, fn := range ctx.finishers {
+		fn()
+	}
+	ctx.finishers = nil
+}
+
+// Get returns the value stored with Set for key.
+func (ctx *Context) Get(key string) (interface{}, bool) {
+	ctx.assertLive()
+	value, ok := ctx.values[key]
+	return value, ok
+}
+
+// Timeout limits the time route r may take to handle a request, replacing
+// ServerConfig.HandlerTimeout. A negative timeout disables the limit.
+func (r *Route) Timeout(timeout time.Duration) *Route {
+	r.timeout = timeout
+	return r
+}
+
+// Timeout limits the time the routes of g may take to handle a request,
+// unless a route sets its own timeout. See Route.Timeout.
+func (g *Group) Timeout(timeout time.Duration) {
+	g.timeout = timeout
+}
+
+// handlerTimeout returns the timeout that applies to route r.
+func (s *Server) handlerTimeout(r *Route) time.Duration {
+	if r.websocket {
+		// a connection outlives any handler timeout
+		return 0
+	}
+	if r.timeout != 0 {
+		return r.timeout
+	}
+	for g := r.group; g != nil; g = g.parent {
+		if g.timeout != 0 {
+			return g.timeout
+		}
+	}
+	if s.Config == nil {
+		return 0
+	}
+	return s.Config.HandlerTimeout
+}
+
+// runWithTimeout runs handler with the request's context canceled after
+// timeout. If the handler does not return in time, the client gets 503
+// Service Unavailable and runWithTimeout returns false; the handler keeps
+// running in the background, but can no longer write to the response. The
+// Context then belongs to the handler, so the caller must not use it
+// anymore except for the response.
+func (s *Server) runWithTimeout(ctx *Context, timeout time.Duration, handler func()) bool {
+	c, cancel := context.WithTimeout(ctx.Request.Context(), timeout)
+	defer cancel()
+	ctx.Request = ctx.Request.WithContext(c)
+	ctx.response.setDeadline(c)
+
+	done := make(chan struct{})
+	panicked := make(chan interface{}, 1)
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				panicked <- err
+			}
+		}()
+		handler()
+		close(done)
+	}()
+
+	completed := true
+	select {
+	case <-done:
+	case err := <-panicked:
+		// continue the panic in the goroutine of the request
+		panic(err)
+	case <-c.Done():
+		completed = false
+	}
+
+	switch c.Err() {
+	case nil:
+		ctx.response.mu.Lock()
+		ctx.response.commitHeader()
+		ctx.response.mu.Unlock()
+	case context.DeadlineExceeded:
+		ctx.response.timeout(503, "Service Unavailable")
+	default:
+		// the client is gone, there is no one to reply to
+		ctx.response.timeout(0, "")
+	}
+	return completed
+}
+
+var contextContextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+
+// getContextContext injects the request's Context as a context.Context.
+func getContextContext(t reflect.Type, values []string, valueIndex int, ctx *Context) (reflect.Value, error) {
+	if t != contextContextType {
+		return reflect.Value{}, NotSupported
+	}
+	if ctx == nil {
+		return reflect.Zero(t), NoValueNeeded
+	}
+	return reflect.ValueOf(ctx), NoValueNeeded
+}
@@ -0,0 +1,153 @@
+package web
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestContextValues(t *testing.T) {
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	s.Use(func(ctx *Context, next func()) {
+		ctx.Set("user", "alice")
+		next()
+	})
+	s.Get("/", func(ctx *Context, c context.Context) string {
+		user, ok := ctx.Get("user")
+		if !ok || c.Value("user") != user {
+			return "missing"
+		}
+		if _, ok := ctx.Get("other"); ok {
+			return "unexpected"
+		}
+		return user.(string)
+	})
+
+	rec := httptest.NewRecorder()
+	s.Process(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Body.String() != "alice" {
+		t.Fatalf("Expected request value, got %q", rec.Body.String())
+	}
+
+	// values do not leak into the next request of a pooled Context
+	ctx := &Context{}
+	ctx.Set("user", "alice")
+	ctx.Reset(httptest.NewRequest("GET", "/", nil), s, httptest.NewRecorder())
+	if ctx.Value("user") != nil {
+		t.Fatalf("Expected Reset to clear the values")
+	}
+}
+
+func TestHandlerTimeout(t *testing.T) {
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	s.Config = &ServerConfig{HandlerTimeout: 20 * time.Millisecond}
+
+	writeErr := make(chan error, 1)
+	s.Get("/slow", func(ctx *Context) string {
+		<-ctx.Done()
+		_, err := ctx.Write([]byte("late"))
+		writeErr <- err
+		return "late"
+	})
+	s.Get("/fast", func(ctx *Context) string {
+		if _, ok := ctx.Deadline(); !ok {
+			return "no deadline"
+		}
+		ctx.SetHeader("X-Fast", "yes", true)
+		return "fast"
+	})
+	s.Get("/unlimited", func(ctx *Context) string {
+		if _, ok := ctx.Deadline(); ok {
+			return "deadline"
+		}
+		return "unlimited"
+	}).Timeout(-1)
+	s.Handle("/handler", "GET", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-req.Context().Done()
+	})).Timeout(10 * time.Millisecond)
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/slow", 503, "Service Unavailable"},
+		{"/fast", 200, "fast"},
+		{"/unlimited", 200, "unlimited"},
+		{"/handler", 503, "Service Unavailable"},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		s.Process(rec, httptest.NewRequest("GET", test.path, nil))
+		if rec.Code != test.status || rec.Body.String() != test.body {
+			t.Errorf("%s: expected %d %q, got %d %q", test.path, test.status, test.body, rec.Code, rec.Body.String())
+		}
+		if test.path == "/fast" && rec.Header().Get("X-Fast") != "yes" {
+			t.Errorf("Expected the header of the handler, got %v", rec.Header())
+		}
+	}
+
+	select {
+	case err := <-writeErr:
+		if err != http.ErrHandlerTimeout {
+			t.Fatalf("Expected writes after the timeout to fail, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the handler to observe the cancellation")
+	}
+}
+
+// TestHandlerTimeoutOverrun is meant for go test -race: the handler keeps
+// using its Context after the request has been answered and logged.
+func TestHandlerTimeoutOverrun(t *testing.T) {
+	s := NewServer()
+	var logOutput lockedBuffer
+	s.SetLogger(log.New(&logOutput, "", 0))
+	s.Config = &ServerConfig{HandlerTimeout: 10 * time.Millisecond}
+
+	done := make(chan struct{})
+	s.Get("/overrun/(.*)", func(ctx *Context, id string) {
+		<-ctx.Done()
+		time.Sleep(10 * time.Millisecond)
+		for i := 0; i < 100; i++ {
+			ctx.Params["id"] = id
+			ctx.Set("step", i)
+		}
+		close(done)
+	})
+
+	rec := httptest.NewRecorder()
+	s.Process(rec, httptest.NewRequest("GET", "/overrun/1?page=2", nil))
+	if rec.Code != 503 {
+		t.Fatalf("Expected 503, got %d", rec.Code)
+	}
+	<-done
+	if entry := logOutput.String(); !strings.Contains(entry, "GET /overrun/1") || !strings.Contains(entry, "page:2") || strings.Contains(entry, "id:") {
+		t.Fatalf("Expected the log entry of the request as it started, got %q", entry)
+	}
+}
+
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf strings.Builder
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
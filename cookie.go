@@ -0,0 +1,121 @@
+package web
+
+import (
+	"net/http"
+	"time"
+)
+
+// permanentCookieAge is the lifetime in seconds given to "permanent"
+// cookies. Browsers cap cookie lifetimes at 400 days.
+const permanentCookieAge = 400 * 24 * 60 * 60
+
+// CookieOptions holds the attributes of cookies set by the server.
+type CookieOptions struct {
+	Path   string
+	Domain string
+	// MaxAge is the lifetime of the cookie in seconds. Zero creates a
+	// session cookie and negative values delete the cookie.
+	MaxAge   int
+	Secure   bool
+	HttpOnly bool
+	SameSite http.SameSite
+}
+
+// DefaultCookieOptions returns the cookie options used when
+// ServerConfig.CookieOptions is nil. Cookies are only marked Secure
+// outside of development mode, so they keep working over plain HTTP
+// on a developer's machine.
+func DefaultCookieOptions(development bool) CookieOptions {
+	return CookieOptions{
+		Path:     "/",
+		Secure:   !development,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// NewCookie returns a cookie with the given name and value and the
+// attributes of o.
+func (o CookieOptions) NewCookie(name string, value string) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     o.Path,
+		Domain:   o.Domain,
+		MaxAge:   o.MaxAge,
+		Secure:   o.Secure,
+		HttpOnly: o.HttpOnly,
+		SameSite: o.SameSite,
+	}
+	if o.MaxAge != 0 {
+		// Expires is still needed by old versions of Internet Explorer
+		cookie.Expires = time.Now().Add(time.Duration(o.MaxAge) * time.Second)
+	}
+	return cookie
+}
+
+// withAge returns a copy of o with the lifetime set from an age in seconds
+// as used by NewCookie and SetSecureCookie: zero is permanent and negative
+// values delete the cookie.
+func (o CookieOptions) withAge(age int64) CookieOptions {
+	switch {
+	case age == 0:
+		o.MaxAge = permanentCookieAge
+	case age < 0:
+		o.MaxAge = -1
+	default:
+		o.MaxAge = int(age)
+	}
+	return o
+}
+
+// cookieOptions returns the configured default cookie options of s.
+func (s *Server) cookieOptions() CookieOptions {
+	if s.Config.CookieOptions != nil {
+		return *s.Config.CookieOptions
+	}
+	return DefaultCookieOptions(s.Config.Development)
+}
+
+// CookieOptions returns a copy of the server's default cookie options. It
+// can be adjusted and passed to SetCookieWithOptions or
+// SetSecureCookieWithOptions.
+func (ctx *Context) CookieOptions() CookieOptions {
+	return ctx.Server.cookieOptions()
+}
+
+// NewCookie returns a cookie with the server's default options. Age is
+// specified in seconds. If it is zero, the cookie is permanent.
+func (ctx *Context) NewCookie(name string, value string, age int64) *http.Cookie {
+	return ctx.Server.cookieOptions().withAge(age).NewCookie(name, value)
+}
+
+// SetCookieWithOptions adds a cookie with the given options to the response.
+func (ctx *Context) SetCookieWithOptions(name string, value string, opts CookieOptions) {
+	ctx.SetCookie(opts.NewCookie(name, value))
+}
+
+// GetCookie returns the value of the request cookie name and whether it
+// was present.
+func (ctx *Context) GetCookie(name string) (string, bool) {
+	cookie, err := ctx.Request.Cookie(name)
+	if err != nil {
+		return "", false
+	}
+	return cookie.Value, true
+}
+
+// DeleteCookie tells the client to remove the cookie name, including the
+// chunks of a large secure value. The cookie is expected to use the
+// server's default path and domain.
+func (ctx *Context) DeleteCookie(name string) {
+	opts := ctx.Server.cookieOptions()
+	opts.MaxAge = -1
+	ctx.SetCookie(opts.NewCookie(name, ""))
+	for i := 1; ; i++ {
+		if _, err := ctx.Request.Cookie(cookieChunkName(name, i)); err != nil {
+			break
+		}
+		ctx.SetCookie(opts.NewCookie(cookieChunkName(name, i), ""))
+	}
+}
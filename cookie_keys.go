@@ -0,0 +1,170 @@
+package web
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// A CookieKey is a secret used to encrypt and sign secure cookies.
+type CookieKey struct {
+	Secret string
+	// Expires ends the grace window of a retired key. Cookies protected by
+	// the key are rejected afterwards. The zero value never expires.
+	Expires time.Time
+}
+
+// A KeyProvider supplies the keys for secure cookies, newest first. The
+// first key protects new cookies, the others are only used to read cookies
+// issued before the secret was rotated.
+type KeyProvider interface {
+	CookieKeys() ([]CookieKey, error)
+}
+
+// StaticKeyProvider is a KeyProvider for a fixed list of keys.
+type StaticKeyProvider []CookieKey
+
+// CookieKeys returns the keys of p.
+func (p StaticKeyProvider) CookieKeys() ([]CookieKey, error) {
+	return p, nil
+}
+
+// FileKeyProvider reads the cookie keys from a file, one key per line and
+// newest first. A retired key may be followed by whitespace and an RFC 3339
+// timestamp at which its grace window ends. Empty lines and lines starting
+// with '#' are ignored.
+type FileKeyProvider string
+
+// CookieKeys reads the keys from the file p.
+func (p FileKeyProvider) CookieKeys() ([]CookieKey, error) {
+	f, err := os.Open(string(p))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readCookieKeys(f)
+}
+
+// EnvKeyProvider reads the cookie keys from the named environment variable.
+// Keys are separated by commas and use the same syntax as FileKeyProvider,
+// except that each secret is base64 encoded, so that it may contain commas.
+type EnvKeyProvider string
+
+// CookieKeys reads the keys from the environment variable p.
+func (p EnvKeyProvider) CookieKeys() ([]CookieKey, error) {
+	value := os.Getenv(string(p))
+	keys, err := readCookieKeys(strings.NewReader(strings.Replace(value, ",", "\n", -1)))
+	if err != nil {
+		return nil, err
+	}
+	for i := range keys {
+		secret, err := base64.StdEncoding.DecodeString(keys[i].Secret)
+		if err != nil {
+			return nil, errors.New("Cookie key in environment variable " + string(p) + " is not base64 encoded")
+		}
+		keys[i].Secret = string(secret)
+	}
+	return keys, nil
+}
+
+func readCookieKeys(r io.Reader) ([]CookieKey, error) {
+	var keys []CookieKey
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key := CookieKey{Secret: line}
+		if pos := strings.LastIndexAny(line, " \t"); pos > 0 {
+			if expires, err := time.Parse(time.RFC3339, line[pos+1:]); err == nil {
+				key.Secret = strings.TrimSpace(line[:pos])
+				key.Expires = expires
+			}
+		}
+		keys = append(keys, key)
+	}
+	return keys, scanner.Err()
+}
+
+// cookieKey holds the keys derived from a CookieKey's secret.
+type cookieKey struct {
+	id      []byte
+	encKey  []byte
+	signKey []byte
+	expires time.Time
+}
+
+func newCookieKey(key CookieKey) *cookieKey {
+	signKey := genKey(key.Secret, "signature key salt")
+	sum := sha256.Sum256(signKey)
+	return &cookieKey{
+		id:      sum[:cookieKeyIDSize],
+		encKey:  genKey(key.Secret, "encryption key salt"),
+		signKey: signKey,
+		expires: key.Expires,
+	}
+}
+
+// keyProvider returns the configured KeyProvider, falling back to
+// ServerConfig.CookieSecret.
+func (s *Server) keyProvider() KeyProvider {
+	if s.Config.CookieKeys != nil {
+		return s.Config.CookieKeys
+	}
+	if len(s.Config.CookieSecret) == 0 {
+		return nil
+	}
+	return StaticKeyProvider{{Secret: s.Config.CookieSecret}}
+}
+
+// ReloadCookieKeys fetches the keys for secure cookies from the key
+// provider again. It is useful after rotating a secret in a key file.
+func (s *Server) ReloadCookieKeys() error {
+	provider := s.keyProvider()
+	if provider == nil {
+		return ErrMissingCookieSecret
+	}
+	keys, err := provider.CookieKeys()
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 || len(keys[0].Secret) == 0 {
+		return errors.New("The cookie key provider returned no keys")
+	}
+
+	derived := make([]*cookieKey, len(keys))
+	for i, key := range keys {
+		derived[i] = newCookieKey(key)
+	}
+	s.keysMu.Lock()
+	s.cookieKeys = derived
+	s.keysMu.Unlock()
+	return nil
+}
+
+// loadCookieKeys returns the current cookie keys, loading them on first use.
+func (s *Server) loadCookieKeys() ([]*cookieKey, error) {
+	s.keysMu.RLock()
+	keys := s.cookieKeys
+	s.keysMu.RUnlock()
+	if keys != nil {
+		return keys, nil
+	}
+
+	if err := s.ReloadCookieKeys(); err != nil {
+		if err != ErrMissingCookieSecret {
+			s.Logger.Println("Error loading cookie keys:", err)
+			err = ErrInvalidKey
+		}
+		return nil, err
+	}
+	s.keysMu.RLock()
+	defer s.keysMu.RUnlock()
+	return s.cookieKeys, nil
+}
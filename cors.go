@@ -0,0 +1,205 @@
+package web
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// CORSConfig configures cross-origin resource sharing for a server or a
+// group of routes.
+type CORSConfig struct {
+	// AllowedOrigins lists the origins that may access the routes. An
+	// entry may be "*" for any origin or contain a single "*" wildcard,
+	// such as "https://*.example.com". "*" can not be combined with
+	// AllowCredentials.
+	AllowedOrigins []string
+	// AllowedMethods lists the methods allowed in preflight requests. If
+	// it is empty, the methods registered for the requested path are used.
+	AllowedMethods []string
+	// AllowedHeaders lists the request headers allowed in preflight
+	// requests. If it is empty, Accept, Content-Type and X-Requested-With
+	// are allowed. "*" allows any header.
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	// MaxAge is the number of seconds the result of a preflight request
+	// may be cached. Zero omits the header.
+	MaxAge int
+}
+
+var defaultCORSHeaders = []string{"Accept", "Content-Type", "X-Requested-With"}
+
+// CORS enables cross-origin requests for all routes of server s that are
+// not in a group with its own configuration. It panics if the
+// configuration allows credentials for any origin.
+func (s *Server) CORS(config CORSConfig) {
+	config.validate()
+	s.cors = &config
+}
+
+// CORS enables cross-origin requests for the routes of group g, replacing
+// the configuration of the server and of enclosing groups. It panics if
+// the configuration allows credentials for any origin.
+func (g *Group) CORS(config CORSConfig) {
+	config.validate()
+	g.cors = &config
+}
+
+// validate panics for "*" with AllowCredentials, which would let every
+// site make credentialed requests.
+func (config *CORSConfig) validate() {
+	if !config.AllowCredentials {
+		return
+	}
+	for _, pattern := range config.AllowedOrigins {
+		if pattern == "*" {
+			panic(`CORS origin "*" can not be used with AllowCredentials`)
+		}
+	}
+}
+
+// corsConfig returns the CORS configuration that applies to route, or nil.
+func (s *Server) corsConfig(route *Route) *CORSConfig {
+	for g := route.group; g != nil; g = g.parent {
+		if g.cors != nil {
+			return g.cors
+		}
+	}
+	return s.cors
+}
+
+// allowOrigin returns the value of Access-Control-Allow-Origin for origin,
+// or an empty string if the origin is not allowed.
+func (config *CORSConfig) allowOrigin(origin string) string {
+	for _, pattern := range config.AllowedOrigins {
+		if pattern == "*" {
+			return "*"
+		}
+		if matchWildcard(pattern, origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+// matchWildcard reports whether s matches pattern, which may contain a
+// single '*' matching any sequence of characters.
+func matchWildcard(pattern string, s string) bool {
+	pos := strings.IndexByte(pattern, '*')
+	if pos < 0 {
+		return strings.EqualFold(pattern, s)
+	}
+	prefix, suffix := pattern[:pos], pattern[pos+1:]
+	return len(s) >= len(prefix)+len(suffix) &&
+		strings.EqualFold(s[:len(prefix)], prefix) &&
+		strings.EqualFold(s[len(s)-len(suffix):], suffix)
+}
+
+// writeHeaders adds the CORS headers for an actual request if its origin
+// is allowed.
+func (config *CORSConfig) writeHeaders(ctx *Context) {
+	header := ctx.ResponseWriter.Header()
+	header.Add("Vary", "Origin")
+	allowed := config.allowOrigin(ctx.Request.Header.Get("Origin"))
+	if allowed == "" {
+		return
+	}
+	header.Set("Access-Control-Allow-Origin", allowed)
+	if config.AllowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if len(config.ExposedHeaders) > 0 {
+		header.Set("Access-Control-Expose-Headers", strings.Join(config.ExposedHeaders, ", "))
+	}
+}
+
+// preflight answers a CORS preflight request. methods are the methods
+// registered for the requested path.
+func (config *CORSConfig) preflight(ctx *Context, methods []string) {
+	header := ctx.ResponseWriter.Header()
+	header.Add("Vary", "Origin")
+	header.Add("Vary", "Access-Control-Request-Method")
+	header.Add("Vary", "Access-Control-Request-Headers")
+
+	allowed := config.allowOrigin(ctx.Request.Header.Get("Origin"))
+	if config.AllowedMethods != nil {
+		methods = config.AllowedMethods
+	}
+	method := ctx.Request.Header.Get("Access-Control-Request-Method")
+	if allowed == "" || !containsFold(methods, method) {
+		ctx.Error(403, "CORS request not allowed")
+		return
+	}
+
+	allowedHeaders := config.AllowedHeaders
+	if allowedHeaders == nil {
+		allowedHeaders = defaultCORSHeaders
+	}
+	requested := ctx.Request.Header.Get("Access-Control-Request-Headers")
+	if !containsFold(allowedHeaders, "*") {
+		for _, h := range strings.Split(requested, ",") {
+			h = strings.TrimSpace(h)
+			if h != "" && !containsFold(allowedHeaders, h) {
+				ctx.Error(403, "CORS request not allowed")
+				return
+			}
+		}
+	}
+
+	header.Set("Access-Control-Allow-Origin", allowed)
+	header.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+	if requested != "" {
+		header.Set("Access-Control-Allow-Headers", requested)
+	}
+	if config.AllowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if config.MaxAge > 0 {
+		header.Set("Access-Control-Max-Age", strconv.Itoa(config.MaxAge))
+	}
+	ctx.ResponseWriter.WriteHeader(http.StatusNoContent)
+}
+
+// handlePreflight answers the request if it is a CORS preflight for a path
+// with CORS enabled. It reports whether the request was handled.
+func (s *Server) handlePreflight(ctx *Context) bool {
+	req := ctx.Request
+	if req.Method != "OPTIONS" || req.Header.Get("Origin") == "" || req.Header.Get("Access-Control-Request-Method") == "" {
+		return false
+	}
+
+	var config *CORSConfig
+	var methods []string
+	for _, route := range s.routes {
+		if route.pathRegex == nil || !route.pathRegex.MatchString(req.URL.Path) {
+			continue
+		}
+		if _, ok := route.matchHost(ctx); !ok {
+			continue
+		}
+		if config == nil {
+			config = s.corsConfig(route)
+		}
+		if !containsFold(methods, route.method) {
+			methods = append(methods, route.method)
+			if route.method == "GET" {
+				methods = append(methods, "HEAD")
+			}
+		}
+	}
+	if config == nil {
+		return false
+	}
+	config.preflight(ctx, methods)
+	return true
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
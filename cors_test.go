@@ -0,0 +1,110 @@
+package web
+
+import (
+	"io/ioutil"
+	"log"
+	"net/http/httptest"
+	"testing"
+)
+
+func newCORSTestServer() *Server {
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	s.Get("/site", func() string { return "site" })
+
+	api := s.Group("/api")
+	api.CORS(CORSConfig{
+		AllowedOrigins:   []string{"https://app.example.com", "https://*.example.org"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization"},
+		ExposedHeaders:   []string{"X-Total-Count"},
+		AllowCredentials: true,
+		MaxAge:           600,
+	})
+	api.Get("/items", func() string { return "items" })
+	api.Put("/items/([0-9]+)", func(id string) string { return id })
+	return s
+}
+
+func corsRequest(s *Server, method string, path string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	s.Process(rec, req)
+	return rec
+}
+
+func TestCORSPreflight(t *testing.T) {
+	s := newCORSTestServer()
+
+	rec := corsRequest(s, "OPTIONS", "/api/items/1", map[string]string{
+		"Origin":                         "https://app.example.com",
+		"Access-Control-Request-Method":  "PUT",
+		"Access-Control-Request-Headers": "content-type, authorization",
+	})
+	if rec.Code != 204 {
+		t.Fatalf("Expected preflight status 204, got %d", rec.Code)
+	}
+	expected := map[string]string{
+		"Access-Control-Allow-Origin":      "https://app.example.com",
+		"Access-Control-Allow-Methods":     "PUT",
+		"Access-Control-Allow-Headers":     "content-type, authorization",
+		"Access-Control-Allow-Credentials": "true",
+		"Access-Control-Max-Age":           "600",
+	}
+	for k, v := range expected {
+		if got := rec.Header().Get(k); got != v {
+			t.Fatalf("Expected %s %q, got %q", k, v, got)
+		}
+	}
+
+	rejected := []map[string]string{
+		{"Origin": "https://evil.com", "Access-Control-Request-Method": "PUT"},
+		{"Origin": "https://app.example.com", "Access-Control-Request-Method": "DELETE"},
+		{"Origin": "https://app.example.com", "Access-Control-Request-Method": "PUT", "Access-Control-Request-Headers": "X-Custom"},
+	}
+	for _, headers := range rejected {
+		if rec := corsRequest(s, "OPTIONS", "/api/items/1", headers); rec.Code != 403 {
+			t.Fatalf("Expected preflight %v to be rejected, got %d", headers, rec.Code)
+		}
+	}
+
+	rec = corsRequest(s, "OPTIONS", "/site", map[string]string{"Origin": "https://app.example.com", "Access-Control-Request-Method": "GET"})
+	if rec.Code != 404 {
+		t.Fatalf("Expected a route without CORS to fall through to 404, got %d", rec.Code)
+	}
+}
+
+func TestCORSActualRequest(t *testing.T) {
+	s := newCORSTestServer()
+
+	rec := corsRequest(s, "GET", "/api/items", map[string]string{"Origin": "https://sub.example.org"})
+	if rec.Body.String() != "items" || rec.Header().Get("Access-Control-Allow-Origin") != "https://sub.example.org" {
+		t.Fatalf("Expected a wildcard origin to be allowed, got %q", rec.Header().Get("Access-Control-Allow-Origin"))
+	}
+	if rec.Header().Get("Access-Control-Expose-Headers") != "X-Total-Count" {
+		t.Fatalf("Expected exposed headers to be set")
+	}
+
+	rec = corsRequest(s, "GET", "/api/items", map[string]string{"Origin": "https://evil.com"})
+	if rec.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatalf("Expected no CORS headers for a foreign origin")
+	}
+
+	s.CORS(CORSConfig{AllowedOrigins: []string{"*"}})
+	rec = corsRequest(s, "GET", "/site", map[string]string{"Origin": "https://evil.com"})
+	if rec.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Fatalf("Expected the server configuration to allow any origin")
+	}
+}
+
+func TestCORSAnyOriginWithCredentials(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("Expected credentials for any origin to be rejected")
+		}
+	}()
+	s := NewServer()
+	s.Group("/api").CORS(CORSConfig{AllowedOrigins: []string{"*"}, AllowCredentials: true})
+}
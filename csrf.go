@@ -0,0 +1,211 @@
+package web
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// CSRFMode selects how the CSRF middleware verifies unsafe requests.
+type CSRFMode int
+
+const (
+	// CSRFSecureCookie stores the token in a secure cookie. Forms and
+	// scripts submit the value of ctx.CSRFToken().
+	CSRFSecureCookie CSRFMode = iota
+	// CSRFDoubleSubmit stores the token in a plain cookie that scripts can
+	// read and echo back in the CSRF header.
+	CSRFDoubleSubmit
+	// CSRFOriginCheck uses no token and only compares the Origin or
+	// Referer header with the request's own origin.
+	CSRFOriginCheck
+)
+
+const csrfTokenSize = 32
+
+// CSRFConfig configures the CSRF middleware. The zero value uses a token
+// in a secure cookie named "_csrf", submitted through the form field
+// "csrf_token" or the header "X-CSRF-Token".
+type CSRFConfig struct {
+	Mode       CSRFMode
+	CookieName string
+	FieldName  string
+	HeaderName string
+	// CheckOrigin verifies the Origin or Referer header in addition to
+	// the token. It is implied by CSRFOriginCheck.
+	CheckOrigin bool
+	// TrustedOrigins lists other origins, such as "https://app.example.com",
+	// that may send unsafe requests.
+	TrustedOrigins []string
+}
+
+type csrfState struct {
+	config *CSRFConfig
+	token  []byte
+}
+
+// CSRF returns middleware that rejects POST, PUT, DELETE and other unsafe
+// requests with 403 unless they carry a valid CSRF token or come from a
+// trusted origin, depending on config.Mode. Routes can opt out with
+// Route.CSRFExempt.
+func CSRF(config CSRFConfig) Middleware {
+	if config.CookieName == "" {
+		config.CookieName = "_csrf"
+	}
+	if config.FieldName == "" {
+		config.FieldName = "csrf_token"
+	}
+	if config.HeaderName == "" {
+		config.HeaderName = "X-CSRF-Token"
+	}
+
+	return func(ctx *Context, next func()) {
+		state := &csrfState{config: &config}
+		ctx.csrf = state
+		if config.Mode != CSRFOriginCheck {
+			state.token = config.readToken(ctx)
+			if state.token == nil {
+				if err := config.issueToken(ctx, state); err != nil {
+					ctx.Server.Logger.Println("Error issuing CSRF token:", err)
+				}
+			}
+		}
+
+		if isSafeMethod(ctx.Request.Method) || ctx.route.csrfExempt {
+			next()
+			return
+		}
+		if (config.CheckOrigin || config.Mode == CSRFOriginCheck) && !config.checkOrigin(ctx) {
+			ctx.Error(403, "Forbidden - cross-origin request")
+			return
+		}
+		if config.Mode != CSRFOriginCheck && !config.checkToken(ctx, state.token) {
+			ctx.Error(403, "Forbidden - invalid CSRF token")
+			return
+		}
+		next()
+	}
+}
+
+// CSRFExempt disables the CSRF check for route r, for example for webhooks
+// that authenticate requests in another way.
+func (r *Route) CSRFExempt() *Route {
+	r.csrfExempt = true
+	return r
+}
+
+// CSRFToken returns the token to submit with unsafe requests, for example
+// in a hidden form field named after CSRFConfig.FieldName. A new random mask
+// is applied on every call, so the token never repeats in responses. It is
+// empty if the CSRF middleware is not used or runs in CSRFOriginCheck mode.
+func (ctx *Context) CSRFToken() string {
+	if ctx.csrf == nil || ctx.csrf.token == nil {
+		return ""
+	}
+	masked := make([]byte, 2*csrfTokenSize)
+	if _, err := io.ReadFull(rand.Reader, masked[:csrfTokenSize]); err != nil {
+		return ""
+	}
+	for i, b := range ctx.csrf.token {
+		masked[csrfTokenSize+i] = masked[i] ^ b
+	}
+	return cookieEncoding.EncodeToString(masked)
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE":
+		return true
+	}
+	return false
+}
+
+// readToken returns the token stored in the request's cookie, or nil.
+func (config *CSRFConfig) readToken(ctx *Context) []byte {
+	var value string
+	var ok bool
+	if config.Mode == CSRFDoubleSubmit {
+		value, ok = ctx.GetCookie(config.CookieName)
+	} else {
+		value, ok = ctx.GetSecureCookie(config.CookieName)
+	}
+	if !ok {
+		return nil
+	}
+	token, err := cookieEncoding.DecodeString(value)
+	if err != nil || len(token) != csrfTokenSize {
+		return nil
+	}
+	return token
+}
+
+// issueToken creates a new token and sets the cookie holding it.
+func (config *CSRFConfig) issueToken(ctx *Context, state *csrfState) error {
+	token := make([]byte, csrfTokenSize)
+	if _, err := io.ReadFull(rand.Reader, token); err != nil {
+		return err
+	}
+	value := cookieEncoding.EncodeToString(token)
+	opts := ctx.Server.cookieOptions()
+	opts.MaxAge = 0
+	if config.Mode == CSRFDoubleSubmit {
+		// scripts need to read the cookie to echo it back
+		opts.HttpOnly = false
+		ctx.SetCookieWithOptions(config.CookieName, value, opts)
+	} else if err := ctx.SetSecureCookieWithOptions(config.CookieName, value, opts); err != nil {
+		return err
+	}
+	state.token = token
+	return nil
+}
+
+// checkToken compares the submitted token with the expected one. Both the
+// masked tokens of CSRFToken and the raw cookie value are accepted.
+func (config *CSRFConfig) checkToken(ctx *Context, expected []byte) bool {
+	if expected == nil {
+		return false
+	}
+	submitted := ctx.Request.Header.Get(config.HeaderName)
+	if submitted == "" {
+		// the field is only read from the body, as tokens in the query
+		// string leak through Referer headers and logs
+		submitted = ctx.Request.PostFormValue(config.FieldName)
+	}
+
+	token, err := cookieEncoding.DecodeString(submitted)
+	if err != nil {
+		return false
+	}
+	if len(token) == 2*csrfTokenSize {
+		for i := 0; i < csrfTokenSize; i++ {
+			token[csrfTokenSize+i] ^= token[i]
+		}
+		token = token[csrfTokenSize:]
+	}
+	return subtle.ConstantTimeCompare(token, expected) == 1
+}
+
+// checkOrigin reports whether the request's Origin, or Referer if there is
+// no Origin header, is the request's own origin or a trusted one.
+func (config *CSRFConfig) checkOrigin(ctx *Context) bool {
+	origin := ctx.Request.Header.Get("Origin")
+	if origin == "" || origin == "null" {
+		referer, err := url.Parse(ctx.Request.Header.Get("Referer"))
+		if err != nil || referer.Host == "" {
+			return false
+		}
+		origin = referer.Scheme + "://" + referer.Host
+	}
+
+	if strings.EqualFold(origin, ctx.Scheme()+"://"+ctx.Host()) {
+		return true
+	}
+	for _, trusted := range config.TrustedOrigins {
+		if strings.EqualFold(origin, trusted) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,107 @@
+package web
+
+import (
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func newCSRFTestServer(config CSRFConfig) *Server {
+	s := NewServer()
+	s.Config = &ServerConfig{CookieSecret: "7C19QRmwf3mHZ9CPAaPQ0hsWeufKd"}
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	s.Use(CSRF(config))
+	s.Get("/form", func(ctx *Context) string { return ctx.CSRFToken() })
+	s.Post("/submit", func() string { return "ok" })
+	s.Post("/hook", func() string { return "hook" }).CSRFExempt()
+	return s
+}
+
+func postForm(s *Server, path string, form url.Values, cookies []*http.Cookie, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	s.Process(rec, req)
+	return rec
+}
+
+func TestCSRFSecureCookie(t *testing.T) {
+	s := newCSRFTestServer(CSRFConfig{})
+
+	rec := httptest.NewRecorder()
+	s.Process(rec, httptest.NewRequest("GET", "/form", nil))
+	token := rec.Body.String()
+	cookies := rec.Result().Cookies()
+	if token == "" || len(cookies) != 1 {
+		t.Fatalf("Expected a token and a CSRF cookie, got %q and %d cookies", token, len(cookies))
+	}
+
+	if rec := postForm(s, "/submit", url.Values{"csrf_token": {token}}, cookies, nil); rec.Code != 200 || rec.Body.String() != "ok" {
+		t.Fatalf("Expected a valid token to be accepted, got %d", rec.Code)
+	}
+	if rec := postForm(s, "/submit", nil, cookies, map[string]string{"X-CSRF-Token": token}); rec.Code != 200 {
+		t.Fatalf("Expected a token in the header to be accepted, got %d", rec.Code)
+	}
+	if rec := postForm(s, "/submit", url.Values{"csrf_token": {token}}, nil, nil); rec.Code != 403 {
+		t.Fatalf("Expected a request without cookie to be rejected, got %d", rec.Code)
+	}
+	if rec := postForm(s, "/submit", url.Values{"csrf_token": {"invalid"}}, cookies, nil); rec.Code != 403 {
+		t.Fatalf("Expected an invalid token to be rejected, got %d", rec.Code)
+	}
+	if rec := postForm(s, "/submit?csrf_token="+url.QueryEscape(token), nil, cookies, nil); rec.Code != 403 {
+		t.Fatalf("Expected a token in the query string to be rejected, got %d", rec.Code)
+	}
+	if rec := postForm(s, "/hook", nil, nil, nil); rec.Code != 200 || rec.Body.String() != "hook" {
+		t.Fatalf("Expected an exempt route to be accepted, got %d", rec.Code)
+	}
+}
+
+func TestCSRFDoubleSubmit(t *testing.T) {
+	s := newCSRFTestServer(CSRFConfig{Mode: CSRFDoubleSubmit, CheckOrigin: true})
+
+	rec := httptest.NewRecorder()
+	s.Process(rec, httptest.NewRequest("GET", "/form", nil))
+	cookie := rec.Result().Cookies()[0]
+	if cookie.HttpOnly {
+		t.Fatalf("The double submit cookie must be readable by scripts")
+	}
+
+	headers := map[string]string{"X-CSRF-Token": cookie.Value, "Origin": "http://example.com"}
+	if rec := postForm(s, "/submit", nil, []*http.Cookie{cookie}, headers); rec.Code != 200 {
+		t.Fatalf("Expected the echoed cookie to be accepted, got %d", rec.Code)
+	}
+	headers["Origin"] = "http://evil.com"
+	if rec := postForm(s, "/submit", nil, []*http.Cookie{cookie}, headers); rec.Code != 403 {
+		t.Fatalf("Expected a foreign origin to be rejected, got %d", rec.Code)
+	}
+}
+
+func TestCSRFOriginCheck(t *testing.T) {
+	s := newCSRFTestServer(CSRFConfig{Mode: CSRFOriginCheck, TrustedOrigins: []string{"https://app.example.com"}})
+
+	tests := []struct {
+		headers map[string]string
+		status  int
+	}{
+		{map[string]string{"Origin": "http://example.com"}, 200},
+		{map[string]string{"Origin": "https://app.example.com"}, 200},
+		{map[string]string{"Referer": "http://example.com/form"}, 200},
+		{map[string]string{"Origin": "https://evil.com"}, 403},
+		{nil, 403},
+	}
+	for _, test := range tests {
+		if rec := postForm(s, "/submit", nil, nil, test.headers); rec.Code != test.status {
+			t.Fatalf("Origin check with %v: expected %d, got %d", test.headers, test.status, rec.Code)
+		}
+	}
+}
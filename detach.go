@@ -0,0 +1,90 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// ErrContextDetached is returned for writes through a Context returned by
+// Clone or Detach, which has no response.
+var ErrContextDetached = errors.New("Context is detached from the response")
+
+const poisonedMessage = "web: Context used after its request finished; use Clone or Detach for background work"
+
+// Clone returns a copy of the Context that stays valid after the handler
+// returns, when ctx itself is reused for another request. The copy has its
+// own Params and values, shares the request and is canceled with it, but
+// can not write to the response.
+func (ctx *Context) Clone() *Context {
+	ctx.assertLive()
+	clone := &Context{
+		Request:        ctx.Request,
+		Params:         make(map[string]string, len(ctx.Params)),
+		Server:         ctx.Server,
+		ResponseWriter: detachedWriter{},
+		route:          ctx.route,
+		csrf:           ctx.csrf,
+		principal:      ctx.principal,
+		jwtPayload:     ctx.jwtPayload,
+		cspNonce:       ctx.cspNonce,
+	}
+	clone.response.finished = true
+	for k, v := range ctx.Params {
+		clone.Params[k] = v
+	}
+	if ctx.values != nil {
+		clone.values = make(map[string]interface{}, len(ctx.values))
+		for k, v := range ctx.values {
+			clone.values[k] = v
+		}
+	}
+	return clone
+}
+
+// Detach returns a copy of the Context like Clone, for background work that
+// outlives the request: it is not canceled when the request ends and has no
+// deadline, but keeps the values of the request's context.
+func (ctx *Context) Detach() *Context {
+	clone := ctx.Clone()
+	clone.Request = ctx.Request.WithContext(detachedContext{ctx.Request.Context()})
+	return clone
+}
+
+// detachedContext keeps the values of a context, but not its cancellation.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}               { return nil }
+func (detachedContext) Err() error                          { return nil }
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
+
+// detachedWriter is the ResponseWriter of cloned contexts.
+type detachedWriter struct{}
+
+func (detachedWriter) Header() http.Header        { return http.Header{} }
+func (detachedWriter) Write([]byte) (int, error)  { return 0, ErrContextDetached }
+func (detachedWriter) WriteHeader(statusCode int) {}
+
+// poison makes every later use of ctx panic, to find handlers that use
+// their Context after returning. Params becomes a nil map, so only writes
+// to it panic. See ServerConfig.PoisonContexts.
+func (ctx *Context) poison() {
+	*ctx = Context{poisoned: true, ResponseWriter: poisonedWriter{}}
+}
+
+func (ctx *Context) assertLive() {
+	if ctx.poisoned {
+		panic(poisonedMessage)
+	}
+}
+
+// poisonedWriter is the ResponseWriter of poisoned contexts.
+type poisonedWriter struct{}
+
+func (poisonedWriter) Header() http.Header        { panic(poisonedMessage) }
+func (poisonedWriter) Write([]byte) (int, error)  { panic(poisonedMessage) }
+func (poisonedWriter) WriteHeader(statusCode int) { panic(poisonedMessage) }
@@ -0,0 +1,96 @@
+package web
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDetach(t *testing.T) {
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+
+	var detached, cloned *Context
+	s.Get("/item", func(ctx *Context) string {
+		ctx.Set("user", "alice")
+		detached = ctx.Detach()
+		cloned = ctx.Clone()
+		return "ok"
+	})
+	s.Get("/other", func(ctx *Context) string {
+		ctx.Set("user", "bob")
+		return "ok"
+	})
+
+	c, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest("GET", "/item?id=1", nil).WithContext(c)
+	s.Process(httptest.NewRecorder(), req)
+	cancel()
+	// reuse the pooled Context for other requests
+	for i := 0; i < 5; i++ {
+		s.Process(httptest.NewRecorder(), httptest.NewRequest("GET", "/other?id=2", nil))
+	}
+
+	if detached.Params["id"] != "1" || detached.Value("user") != "alice" || detached.Request.URL.RawQuery != "id=1" {
+		t.Fatalf("Expected detached snapshot of the first request, got %v", detached.Params)
+	}
+	if detached.Err() != nil || detached.Done() != nil {
+		t.Fatalf("Expected detached Context not to be canceled")
+	}
+	if cloned.Err() != context.Canceled {
+		t.Fatalf("Expected cloned Context to be canceled with the request, got %v", cloned.Err())
+	}
+	if _, err := detached.Write([]byte("late")); err != ErrContextDetached {
+		t.Fatalf("Expected writes to fail, got %v", err)
+	}
+	detached.Abort(500, "late")
+}
+
+func TestPoisonContexts(t *testing.T) {
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	s.Config = &ServerConfig{PoisonContexts: true}
+
+	var leaked *Context
+	s.Get("/", func(ctx *Context) string {
+		leaked = ctx
+		return "ok"
+	})
+	rec := httptest.NewRecorder()
+	s.Process(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Body.String() != "ok" {
+		t.Fatalf("Expected normal response, got %q", rec.Body.String())
+	}
+
+	uses := map[string]func(){
+		"Write": func() { leaked.WriteString("late") },
+		"Get":   func() { leaked.Get("user") },
+		"Err":   func() { leaked.Err() },
+		"Clone": func() { leaked.Clone() },
+	}
+	for name, use := range uses {
+		func() {
+			defer func() {
+				if recover() != poisonedMessage {
+					t.Errorf("Expected %s on a poisoned Context to panic", name)
+				}
+			}()
+			use()
+		}()
+	}
+
+	// reads of the Params map can not be detected, writes can
+	if leaked.Params["id"] != "" {
+		t.Fatalf("Expected a poisoned Context to have no params")
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("Expected writing the params of a poisoned Context to panic")
+			}
+		}()
+		leaked.Params["id"] = "1"
+	}()
+}
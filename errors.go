@@ -0,0 +1,155 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// NotFound sets the handler for requests that match no route. It accepts
+// the same handler types as Get; see ErrorHandler.
+func (s *Server) NotFound(handler interface{}) {
+	s.ErrorHandler(404, handler)
+}
+
+// ErrorHandler sets the handler for the error responses with status that
+// the server generates, such as 404 for unknown paths, 401 and 403 from
+// authentication and 500 for panics. It accepts the same handler types as
+// Get, but functions may only take a *Context and a string argument, which
+// receives the error message. The response has the status unless the
+// handler sets another one. ErrorHandler panics for invalid handlers.
+func (s *Server) ErrorHandler(status int, handler interface{}) {
+	route := s.newErrorHandlerRoute(status, handler)
+	if s.errorHandlers == nil {
+		s.errorHandlers = map[int]*Route{}
+	}
+	s.errorHandlers[status] = route
+}
+
+// NotFound sets the handler for requests below the prefix of g that match
+// no route, replacing the handler of the server and of enclosing groups.
+func (g *Group) NotFound(handler interface{}) {
+	g.ErrorHandler(404, handler)
+}
+
+// ErrorHandler sets the handler for error responses with status for the
+// routes of g, replacing the handler of the server and of enclosing groups.
+// See Server.ErrorHandler.
+func (g *Group) ErrorHandler(status int, handler interface{}) {
+	route := g.server.newErrorHandlerRoute(status, handler)
+	if g.errorHandlers == nil {
+		g.errorHandlers = map[int]*Route{}
+	}
+	g.errorHandlers[status] = route
+}
+
+// newErrorHandlerRoute returns the route for an error handler. It panics
+// if the handler takes other arguments than a *Context and a string.
+func (s *Server) newErrorHandlerRoute(status int, handler interface{}) *Route {
+	if _, ok := handler.(http.Handler); !ok {
+		if t := reflect.TypeOf(handler); t != nil && t.Kind() == reflect.Func {
+			messages := 0
+			for i := 0; i < t.NumIn(); i++ {
+				switch in := t.In(i); {
+				case in.Kind() == reflect.Ptr && in.Elem() == contextType:
+				case in.Kind() == reflect.String && messages == 0:
+					messages++
+				default:
+					panic(fmt.Sprintf("Error handler for status %d can not take an argument of type %s", status, in))
+				}
+			}
+		}
+	}
+	route, err := s.newHandlerRoute("", nil, "", handler)
+	if err != nil {
+		panic(fmt.Sprintf("Error in handler for status %d: %v", status, err))
+	}
+	return route
+}
+
+// Error replies with status using the error handler registered for it, or
+// else like Abort with message as the body. Errors of a running error
+// handler are always answered like Abort, so that handlers can not recurse.
+func (ctx *Context) Error(status int, message string) {
+	if ctx.finished() {
+		return
+	}
+	var handler *Route
+	if ctx.Server != nil && ctx.errorStatus == 0 {
+		handler = ctx.Server.errorHandler(ctx, status)
+	}
+	if handler == nil {
+		ctx.Abort(status, message)
+		return
+	}
+
+	ctx.response.setStatus(status)
+	// the handler may panic on paths that are not already guarded, such
+	// as the one for unknown routes
+	outer := ctx.errorStatus
+	ctx.errorStatus = status
+	ctx.Server.safely(ctx, func() {
+		if handler.httpHandler != nil {
+			handler.httpHandler.ServeHTTP(ctx.ResponseWriter, ctx.Request)
+		} else {
+			ctx.Server.callHandler(ctx, handler, []string{ctx.Request.URL.Path, message})
+		}
+	})
+	ctx.errorStatus = outer
+	ctx.ResponseWriter.WriteHeader(status)
+	ctx.response.finish()
+}
+
+// errorHandler returns the handler for status that applies to the request:
+// the one of the innermost group of the matched route, or for requests
+// without a route the one of the innermost group whose prefix matches.
+func (s *Server) errorHandler(ctx *Context, status int) *Route {
+	var group *Group
+	if ctx.route != nil {
+		group = ctx.route.group
+	} else {
+		group = s.groupForPath(ctx)
+	}
+	for g := group; g != nil; g = g.parent {
+		if handler := g.errorHandlers[status]; handler != nil {
+			return handler
+		}
+	}
+	return s.errorHandlers[status]
+}
+
+// groupForPath returns the group with the longest prefix that matches the
+// path and host of the request, or nil.
+func (s *Server) groupForPath(ctx *Context) *Group {
+	var best *Group
+	for _, g := range s.groups {
+		if best != nil && len(g.prefix) <= len(best.prefix) {
+			continue
+		}
+		if g.matchPrefix(ctx.Request.URL.Path) && g.matchHost(ctx) {
+			best = g
+		}
+	}
+	return best
+}
+
+// matchPrefix reports whether path starts with the prefix of g, which may
+// be a regular expression like the paths of routes.
+func (g *Group) matchPrefix(path string) bool {
+	if g.prefixRegex == nil {
+		return strings.HasPrefix(path, g.prefix)
+	}
+	return g.prefixRegex.MatchString(path)
+}
+
+// matchHost reports whether the request matches the host pattern of g or
+// of its nearest enclosing group.
+func (g *Group) matchHost(ctx *Context) bool {
+	for ; g != nil; g = g.parent {
+		if g.host != nil {
+			return g.host.regex.MatchString(hostOnly(ctx.Host()))
+		}
+	}
+	return true
+}
@@ -0,0 +1,148 @@
+package web
+
+import (
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestErrorHandlers(t *testing.T) {
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	s.Config = &ServerConfig{RecoverPanic: true}
+
+	s.NotFound(func(ctx *Context, message string) string {
+		return "<h1>" + message + ": " + ctx.Request.URL.Path + "</h1>"
+	})
+	s.ErrorHandler(500, func(message string) string { return "site error, " + message })
+	s.Get("/page", func() string { return "page" })
+	s.Get("/crash", func() string { panic("crash") })
+
+	api := s.Group("/api")
+	api.NotFound(func(ctx *Context) string {
+		ctx.ContentType("json")
+		return `{"error":"not found"}`
+	})
+	api.ErrorHandler(401, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"error":"unauthorized"}`))
+	}))
+	api.ErrorHandler(403, func(ctx *Context) {
+		ctx.WriteHeader(418)
+	})
+	api.Get("/items", func() string { return "[]" })
+	api.Get("/secret", func() string { return "secret" }).RequireAuth()
+	api.Get("/admin", func() string { return "admin" }).RequireRole("admin").Use(func(ctx *Context, next func()) {
+		ctx.SetPrincipal(&Principal{Name: "bob"})
+		next()
+	})
+	// the group's handlers also apply to routes that do not use them
+	api.Get("/crash", func() string { panic("crash") })
+
+	tests := []struct {
+		path        string
+		status      int
+		contentType string
+		body        string
+	}{
+		{"/page", 200, "text/html; charset=utf-8", "page"},
+		{"/missing", 404, "text/html; charset=utf-8", "<h1>Page not found: /missing</h1>"},
+		{"/api/missing", 404, "application/json", `{"error":"not found"}`},
+		{"/api/secret", 401, "application/json", `{"error":"unauthorized"}`},
+		{"/api/admin", 418, "text/html; charset=utf-8", ""},
+		{"/crash", 500, "text/html; charset=utf-8", "site error, Error ID: "},
+		{"/api/crash", 500, "text/html; charset=utf-8", "site error, Error ID: "},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		s.Process(rec, httptest.NewRequest("GET", test.path, nil))
+		if rec.Code != test.status || !strings.HasPrefix(rec.Body.String(), test.body) {
+			t.Errorf("%s: expected %d %q, got %d %q", test.path, test.status, test.body, rec.Code, rec.Body.String())
+		}
+		if got := rec.Header().Get("Content-Type"); got != test.contentType {
+			t.Errorf("%s: expected Content-Type %q, got %q", test.path, test.contentType, got)
+		}
+	}
+}
+
+func TestErrorHandlerPanics(t *testing.T) {
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	s.Config = &ServerConfig{RecoverPanic: true, AllowedHosts: []string{"example.com"}}
+	s.NotFound(func() string { panic("not found handler") })
+	s.ErrorHandler(400, func() string { panic("bad request handler") })
+	s.ErrorHandler(500, func() string { panic("error handler") })
+
+	var recovered []interface{}
+	for _, host := range []string{"example.com", "other.com"} {
+		rec := httptest.NewRecorder()
+		s.Process(rec, httptest.NewRequest("GET", "http://"+host+"/missing", nil))
+		if rec.Code != 500 || !strings.Contains(rec.Body.String(), "Server Error") {
+			t.Errorf("%s: expected the default error page, got %d %q", host, rec.Code, rec.Body.String())
+		}
+	}
+
+	s.PanicHandler = func(ctx *Context, err interface{}, stack []byte) {
+		recovered = append(recovered, err)
+		ctx.Abort(500, "recovered")
+	}
+	rec := httptest.NewRecorder()
+	s.Process(rec, httptest.NewRequest("GET", "http://example.com/missing", nil))
+	if len(recovered) != 1 || recovered[0] != "not found handler" || rec.Body.String() != "recovered" {
+		t.Fatalf("Expected the PanicHandler to recover the panic, got %v %q", recovered, rec.Body.String())
+	}
+}
+
+func TestRecursiveErrorHandlers(t *testing.T) {
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	s.NotFound(func(ctx *Context) {
+		if strings.HasPrefix(ctx.Request.URL.Path, "/api/") {
+			ctx.Error(404, "no such resource")
+			return
+		}
+		ctx.WriteString("page not found")
+	})
+	s.ErrorHandler(401, func(ctx *Context) { ctx.Error(403, "forbidden") })
+	s.ErrorHandler(403, func(ctx *Context) { ctx.Error(401, "unauthorized") })
+	s.Get("/private", func(ctx *Context) { ctx.Error(401, "") })
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/missing", 404, "page not found"},
+		{"/api/missing", 404, "no such resource"},
+		{"/private", 403, "forbidden"},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		s.Process(rec, httptest.NewRequest("GET", test.path, nil))
+		if rec.Code != test.status || rec.Body.String() != test.body {
+			t.Errorf("%s: expected %d %q, got %d %q", test.path, test.status, test.body, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestInvalidErrorHandlers(t *testing.T) {
+	handlers := []interface{}{
+		func(code int) string { return "" },
+		func(a string, b string) string { return "" },
+		func() (int, error) { return 0, nil },
+		"not a function",
+	}
+	for _, handler := range handlers {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expected the error handler %T to be rejected", handler)
+				}
+			}()
+			NewServer().Group("/api").ErrorHandler(500, handler)
+		}()
+	}
+}
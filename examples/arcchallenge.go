@@ -9,7 +9,7 @@ import (
 	"github.com/JaCoB1123/web"
 )
 
-var form = `<form action="say" method="POST"><input name="said"><input type="submit"></form>`
+var form = `<form action="say" method="POST"><input type="hidden" name="csrf_token" value="%s"><input name="said"><input type="submit"></form>`
 
 var users = map[string]string{}
 
@@ -21,7 +21,8 @@ func main() {
 	server.Get("/", func(ctx *web.Context) {
 		ctx.Redirect(302, "/said")
 	})
-	server.Get("/said", func() string { return form })
+	server.Use(web.CSRF(web.CSRFConfig{}))
+	server.Get("/said", func(ctx *web.Context) string { return fmt.Sprintf(form, ctx.CSRFToken()) })
 	server.Post("/say", func(ctx *web.Context) string {
 		uid := fmt.Sprintf("%d\n", rand.Int63())
 		ctx.SetSecureCookie("user", uid, 3600)
@@ -26,12 +26,12 @@ var form = `
 `
 
 func index(ctx *web.Context) string {
-	cookie, _ := ctx.Request.Cookie(cookieName)
+	cookie, ok := ctx.GetCookie(cookieName)
 	var top string
-	if cookie == nil {
+	if !ok {
 		top = fmt.Sprintf(notice, "The cookie has not been set")
 	} else {
-		var val = html.EscapeString(cookie.Value)
+		var val = html.EscapeString(cookie)
 		top = fmt.Sprintf(notice, "The value of the cookie is '"+val+"'.")
 	}
 	return top + form
@@ -39,15 +39,16 @@ func index(ctx *web.Context) string {
 
 func update(ctx *web.Context) {
 	if ctx.Params["submit"] == "Delete" {
-		ctx.SetCookie(web.NewCookie(cookieName, "", -1))
+		ctx.DeleteCookie(cookieName)
 	} else {
-		ctx.SetCookie(web.NewCookie(cookieName, ctx.Params["cookie"], 0))
+		ctx.SetCookie(ctx.NewCookie(cookieName, ctx.Params["cookie"], 0))
 	}
 	ctx.Redirect(301, "/")
 }
 
 func main() {
 	server := web.NewServer()
+	server.Config.Development = true
 	server.Get("/", index)
 	server.Post("/update", update)
 	http.ListenAndServe("0.0.0.0:9999", server)
@@ -39,7 +39,7 @@ func index(ctx *web.Context) string {
 
 func update(ctx *web.Context) {
 	if ctx.Params["submit"] == "Delete" {
-		ctx.SetCookie(web.NewCookie(cookieName, "", -1))
+		ctx.DeleteCookie(cookieName)
 	} else {
 		ctx.SetSecureCookie(cookieName, ctx.Params["cookie"], 0)
 	}
@@ -49,6 +49,7 @@ func update(ctx *web.Context) {
 func main() {
 	server := web.NewServer()
 	server.Config.CookieSecret = "a long secure cookie secret"
+	server.Config.Development = true
 	server.Get("/", index)
 	server.Post("/update", update)
 	http.ListenAndServe("0.0.0.0:9999", server)
@@ -9,7 +9,11 @@ import (
 )
 
 func hello(ctx *web.Context, num string) {
-	flusher, _ := ctx.ResponseWriter.(http.Flusher)
+	flusher, ok := ctx.ResponseWriter.(http.Flusher)
+	if !ok {
+		ctx.Abort(500, "Streaming is not supported")
+		return
+	}
 	flusher.Flush()
 	n, _ := strconv.ParseInt(num, 10, 64)
 	for i := int64(0); i < n; i++ {
@@ -19,8 +23,27 @@ func hello(ctx *web.Context, num string) {
 	}
 }
 
+func clock(ctx *web.Context) {
+	events, err := ctx.SSE()
+	if err != nil {
+		ctx.Abort(500, err.Error())
+		return
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			events.Send(web.Event{Event: "tick", Data: now.Format(time.RFC3339)})
+		case <-events.Done():
+			return
+		}
+	}
+}
+
 func main() {
 	server := web.NewServer()
 	server.Get("/([0-9]+)", hello)
+	server.Get("/clock", clock)
 	http.ListenAndServe("0.0.0.0:9999", server)
 }
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/JaCoB1123/web"
+)
+
+func site(val string) string {
+	return "site " + val + "\n"
+}
+
+func tenant(name string, val string) string {
+	return "tenant " + name + ": " + val + "\n"
+}
+
+func main() {
+	server := web.NewServer()
+	server.Config.AllowedHosts = []string{"localhost", "*.localhost"}
+
+	server.Get("/(.*)", site).Host("localhost")
+
+	tenants := server.Group("")
+	tenants.Host("*.localhost")
+	tenants.Get("/(.*)", tenant)
+
+	http.ListenAndServe("0.0.0.0:9999", server)
+}
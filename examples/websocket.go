@@ -0,0 +1,22 @@
+package main
+
+import (
+	"github.com/JaCoB1123/web"
+	"golang.org/x/net/websocket"
+)
+
+func chat(ctx *web.Context, conn *websocket.Conn, room string) {
+	var msg string
+	for websocket.Message.Receive(conn, &msg) == nil {
+		websocket.Message.Send(conn, room+": "+msg)
+	}
+}
+
+func main() {
+	server := web.NewServer()
+	server.Config = &web.ServerConfig{
+		WebSocket: &web.WebSocketConfig{Subprotocols: []string{"chat"}},
+	}
+	server.WebSocket("/chat/(\\w+)", chat)
+	server.Run("0.0.0.0:9999")
+}
@@ -0,0 +1,99 @@
+package web
+
+import (
+	"net/http"
+	"regexp"
+	"time"
+)
+
+// A Group registers routes below a common path prefix. The routes of a
+// group share its middleware and settings, which also apply to nested
+// groups.
+type Group struct {
+	server          *Server
+	parent          *Group
+	prefix          string
+	middleware      []Middleware
+	cors            *CORSConfig
+	requirements    []requirement
+	securityHeaders *SecurityHeadersConfig
+	host            *hostPattern
+	timeout         time.Duration
+	errorHandlers   map[int]*Route
+	prefixRegex     *regexp.Regexp
+}
+
+// Group returns a new group of routes below prefix.
+func (s *Server) Group(prefix string) *Group {
+	return s.newGroup(nil, prefix)
+}
+
+// Group returns a new group nested in g. Its prefix is appended to g's.
+func (g *Group) Group(prefix string) *Group {
+	return g.server.newGroup(g, g.prefix+prefix)
+}
+
+func (s *Server) newGroup(parent *Group, prefix string) *Group {
+	g := &Group{server: s, parent: parent, prefix: prefix}
+	// an invalid regex is matched literally by matchPrefix; the routes of
+	// the group report the error
+	g.prefixRegex, _ = regexp.Compile("^" + prefix)
+	s.groups = append(s.groups, g)
+	return g
+}
+
+// Use adds middleware that runs for every route of g, after the middleware
+// of the server and of enclosing groups.
+func (g *Group) Use(middleware ...Middleware) {
+	g.middleware = append(g.middleware, middleware...)
+}
+
+func (g *Group) addRoute(route string, method string, handler interface{}) *Route {
+	r := g.server.addRoute(g.prefix+route, method, handler)
+	r.group = g
+	return r
+}
+
+// Head adds a handler for the 'HEAD' http method for group g.
+func (g *Group) Head(route string, handler interface{}) *Route {
+	return g.addRoute(route, "GET", handler)
+}
+
+// Get adds a handler for the 'GET' http method for group g.
+func (g *Group) Get(route string, handler interface{}) *Route {
+	return g.addRoute(route, "GET", handler)
+}
+
+// Post adds a handler for the 'POST' http method for group g.
+func (g *Group) Post(route string, handler interface{}) *Route {
+	return g.addRoute(route, "POST", handler)
+}
+
+// Put adds a handler for the 'PUT' http method for group g.
+func (g *Group) Put(route string, handler interface{}) *Route {
+	return g.addRoute(route, "PUT", handler)
+}
+
+// Delete adds a handler for the 'DELETE' http method for group g.
+func (g *Group) Delete(route string, handler interface{}) *Route {
+	return g.addRoute(route, "DELETE", handler)
+}
+
+// Match adds a handler for an arbitrary http method for group g.
+func (g *Group) Match(method string, route string, handler interface{}) *Route {
+	return g.addRoute(route, method, handler)
+}
+
+// Handle adds a custom http.Handler for group g.
+func (g *Group) Handle(route string, method string, httpHandler http.Handler) *Route {
+	return g.addRoute(route, method, httpHandler)
+}
+
+// groups returns the groups enclosing route r, outermost first.
+func (r *Route) groups() []*Group {
+	var groups []*Group
+	for g := r.group; g != nil; g = g.parent {
+		groups = append([]*Group{g}, groups...)
+	}
+	return groups
+}
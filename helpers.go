@@ -57,34 +57,28 @@ func Slug(s string, sep string) string {
 
 // NewCookie is a helper method that returns a new http.Cookie object.
 // Duration is specified in seconds. If the duration is zero, the cookie is permanent.
-// This can be used in conjunction with ctx.SetCookie.
+// This can be used in conjunction with ctx.SetCookie. Use ctx.NewCookie to
+// apply the server's default cookie options.
 func NewCookie(name string, value string, age int64) *http.Cookie {
-	var utctime time.Time
-	if age == 0 {
-		// 2^31 - 1 seconds (roughly 2038)
-		utctime = time.Unix(2147483647, 0)
-	} else {
-		utctime = time.Unix(time.Now().Unix()+age, 0)
-	}
-	return &http.Cookie{Name: name, Value: value, Expires: utctime}
+	return CookieOptions{}.withAge(age).NewCookie(name, value)
 }
 
 // GetBasicAuth returns the decoded user and password from the context's
-// 'Authorization' header.
+// 'Authorization' header. The password may contain colons.
 func (ctx *Context) GetBasicAuth() (string, string, error) {
 	if len(ctx.Request.Header["Authorization"]) == 0 {
 		return "", "", errors.New("No Authorization header provided")
 	}
 	authHeader := ctx.Request.Header["Authorization"][0]
-	authString := strings.Split(string(authHeader), " ")
-	if authString[0] != "Basic" {
+	authString := strings.SplitN(authHeader, " ", 2)
+	if len(authString) != 2 || !strings.EqualFold(authString[0], "Basic") {
 		return "", "", errors.New("Not Basic Authentication")
 	}
-	decodedAuth, err := base64.StdEncoding.DecodeString(authString[1])
+	decodedAuth, err := base64.StdEncoding.DecodeString(strings.TrimSpace(authString[1]))
 	if err != nil {
 		return "", "", err
 	}
-	authSlice := strings.Split(string(decodedAuth), ":")
+	authSlice := strings.SplitN(string(decodedAuth), ":", 2)
 	if len(authSlice) != 2 {
 		return "", "", errors.New("Error delimiting authString into username/password. Malformed input: " + authString[1])
 	}
@@ -0,0 +1,82 @@
+package web
+
+import (
+	"regexp"
+	"strings"
+)
+
+// hostPattern is a compiled host pattern of a route or group.
+type hostPattern struct {
+	pattern string
+	regex   *regexp.Regexp
+}
+
+// newHostPattern compiles pattern, in which each '*' matches and captures
+// a single label of the host name.
+func newHostPattern(pattern string) *hostPattern {
+	parts := strings.Split(pattern, "*")
+	for i := range parts {
+		parts[i] = regexp.QuoteMeta(parts[i])
+	}
+	return &hostPattern{
+		pattern: pattern,
+		regex:   regexp.MustCompile("(?i)^" + strings.Join(parts, "([^.]+)") + "$"),
+	}
+}
+
+// Host restricts route r to requests for hosts matching pattern, such as
+// "api.example.com" or "*.example.com". Each '*' matches one label of the
+// host name, which is passed to the handler before the values captured
+// from the path.
+func (r *Route) Host(pattern string) *Route {
+	r.host = newHostPattern(pattern)
+	return r
+}
+
+// Host restricts the routes of g to requests for hosts matching pattern,
+// unless a route sets its own pattern. See Route.Host.
+func (g *Group) Host(pattern string) {
+	g.host = newHostPattern(pattern)
+}
+
+// hostPattern returns the host pattern of r or of its nearest group, or nil.
+func (r *Route) hostPattern() *hostPattern {
+	if r.host != nil {
+		return r.host
+	}
+	for g := r.group; g != nil; g = g.parent {
+		if g.host != nil {
+			return g.host
+		}
+	}
+	return nil
+}
+
+// matchHost reports whether route r accepts the host of the request and
+// returns the labels captured by its pattern.
+func (r *Route) matchHost(ctx *Context) ([]string, bool) {
+	pattern := r.hostPattern()
+	if pattern == nil {
+		return nil, true
+	}
+	match := pattern.regex.FindStringSubmatch(hostOnly(ctx.Host()))
+	if match == nil {
+		return nil, false
+	}
+	return match[1:], true
+}
+
+// allowedHost reports whether the host of the request matches one of
+// ServerConfig.AllowedHosts. Any host is allowed if the list is empty.
+func (s *Server) allowedHost(ctx *Context) bool {
+	if s.Config == nil || len(s.Config.AllowedHosts) == 0 {
+		return true
+	}
+	host := hostOnly(ctx.Host())
+	for _, pattern := range s.Config.AllowedHosts {
+		if matchWildcard(pattern, host) {
+			return true
+		}
+	}
+	return false
+}
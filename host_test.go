@@ -0,0 +1,49 @@
+package web
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHostRouting(t *testing.T) {
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	s.Config = &ServerConfig{AllowedHosts: []string{"example.com", "*.example.com"}}
+
+	s.Get("/", func() string { return "main" }).Host("example.com")
+	tenants := s.Group("/t")
+	tenants.Host("*.example.com")
+	tenants.Get("/([0-9]+)", func(tenant string, id int) string { return fmt.Sprint(tenant, " ", id) })
+	tenants.Get("/admin", func() string { return "admin" }).Host("admin.example.com")
+	s.Get("/any", func() string { return "any" })
+
+	tests := []struct {
+		host   string
+		path   string
+		status int
+		body   string
+	}{
+		{"example.com", "/", 200, "main"},
+		{"EXAMPLE.com:8080", "/", 200, "main"},
+		{"www.example.com", "/", 404, "Page not found"},
+		{"acme.example.com", "/t/42", 200, "acme 42"},
+		{"a.b.example.com", "/t/42", 404, "Page not found"},
+		{"example.com", "/t/42", 404, "Page not found"},
+		{"admin.example.com", "/t/admin", 200, "admin"},
+		{"acme.example.com", "/t/admin", 404, "Page not found"},
+		{"acme.example.com", "/any", 200, "any"},
+		{"evil.com", "/any", 400, "Invalid host"},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", test.path, nil)
+		req.Host = test.host
+		rec := httptest.NewRecorder()
+		s.Process(rec, req)
+		if rec.Code != test.status || rec.Body.String() != test.body {
+			t.Errorf("%s%s: expected %d %q, got %d %q", test.host, test.path, test.status, test.body, rec.Code, rec.Body.String())
+		}
+	}
+}
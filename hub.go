@@ -0,0 +1,261 @@
+package web
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultHubBufferSize is the number of messages buffered for each
+// subscriber.
+const DefaultHubBufferSize = 64
+
+// ErrSlowConsumer is the error of a subscription that was closed because
+// its subscriber did not keep up with the messages.
+var ErrSlowConsumer = errors.New("Subscriber is too slow")
+
+// A SlowConsumerPolicy decides what happens to a message for a subscriber
+// whose buffer is full.
+type SlowConsumerPolicy int
+
+const (
+	// DropOldest discards the oldest buffered message to make room.
+	DropOldest SlowConsumerPolicy = iota
+	// DropNewest discards the new message.
+	DropNewest
+	// Disconnect closes the subscription with ErrSlowConsumer.
+	Disconnect
+)
+
+// HubConfig configures the Hub of a server.
+type HubConfig struct {
+	// BufferSize is the number of messages buffered for each subscriber.
+	// Zero means DefaultHubBufferSize.
+	BufferSize int
+	// SlowConsumer is the policy for subscribers whose buffer is full.
+	SlowConsumer SlowConsumerPolicy
+}
+
+// A Message is published to the subscribers of its topic.
+type Message struct {
+	Topic string
+	Data  interface{}
+}
+
+// HubStats are counters of a Hub.
+type HubStats struct {
+	// Subscribers is the number of open subscriptions.
+	Subscribers int
+	// Topics is the number of topics with subscribers.
+	Topics    int
+	Published uint64
+	// Dropped counts the messages discarded for slow subscribers.
+	Dropped uint64
+	// Disconnected counts the subscriptions closed for being slow.
+	Disconnected uint64
+}
+
+// A Hub broadcasts messages to the subscribers of topics, typically the
+// streaming handlers of SSE or WebSocket routes. It is safe for concurrent
+// use.
+type Hub struct {
+	// the counters come first to be aligned for atomic access
+	published    uint64
+	dropped      uint64
+	disconnected uint64
+	config       HubConfig
+	mu           sync.RWMutex
+	topics       map[string]map[*Subscription]struct{}
+	subscribers  int
+}
+
+// NewHub returns a Hub configured by config.
+func NewHub(config HubConfig) *Hub {
+	if config.BufferSize <= 0 {
+		config.BufferSize = DefaultHubBufferSize
+	}
+	return &Hub{config: config, topics: map[string]map[*Subscription]struct{}{}}
+}
+
+// Hub returns the hub of server s, which is configured by ServerConfig.Hub.
+func (s *Server) Hub() *Hub {
+	s.hubOnce.Do(func() {
+		var config HubConfig
+		if s.Config != nil && s.Config.Hub != nil {
+			config = *s.Config.Hub
+		}
+		s.hub = NewHub(config)
+	})
+	return s.hub
+}
+
+// Subscribe subscribes to topics for the rest of the request. The
+// subscription is closed when the handler returns.
+func (ctx *Context) Subscribe(topics ...string) *Subscription {
+	sub := ctx.Server.Hub().Subscribe(topics...)
+	ctx.onFinish(sub.Close)
+	return sub
+}
+
+// Subscribe returns a subscription to topics. It must be closed when it is
+// no longer used.
+func (h *Hub) Subscribe(topics ...string) *Subscription {
+	sub := &Subscription{
+		hub:      h,
+		topics:   topics,
+		messages: make(chan Message, h.config.BufferSize),
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, topic := range topics {
+		subs := h.topics[topic]
+		if subs == nil {
+			subs = map[*Subscription]struct{}{}
+			h.topics[topic] = subs
+		}
+		subs[sub] = struct{}{}
+	}
+	h.subscribers++
+	return sub
+}
+
+// Publish sends data to the subscribers of topic without blocking and
+// returns the number of subscribers that received it.
+func (h *Hub) Publish(topic string, data interface{}) int {
+	msg := Message{Topic: topic, Data: data}
+	var slowSubs []*Subscription
+	count := 0
+	h.mu.RLock()
+	for sub := range h.topics[topic] {
+		switch sub.deliver(msg, h.config.SlowConsumer) {
+		case delivered:
+			count++
+		case slow:
+			slowSubs = append(slowSubs, sub)
+		}
+	}
+	h.mu.RUnlock()
+	atomic.AddUint64(&h.published, 1)
+	// the subscriptions are removed once the hub is no longer locked
+	for _, sub := range slowSubs {
+		sub.close(ErrSlowConsumer)
+	}
+	return count
+}
+
+// Subscribers returns the number of subscribers of topic.
+func (h *Hub) Subscribers(topic string) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.topics[topic])
+}
+
+// Stats returns the counters of h.
+func (h *Hub) Stats() HubStats {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return HubStats{
+		Subscribers:  h.subscribers,
+		Topics:       len(h.topics),
+		Published:    atomic.LoadUint64(&h.published),
+		Dropped:      atomic.LoadUint64(&h.dropped),
+		Disconnected: atomic.LoadUint64(&h.disconnected),
+	}
+}
+
+func (h *Hub) remove(sub *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, topic := range sub.topics {
+		subs := h.topics[topic]
+		delete(subs, sub)
+		if len(subs) == 0 {
+			delete(h.topics, topic)
+		}
+	}
+	h.subscribers--
+}
+
+// A Subscription receives the messages published to its topics.
+type Subscription struct {
+	hub      *Hub
+	topics   []string
+	messages chan Message
+	mu       sync.Mutex
+	closed   bool
+	err      error
+}
+
+// Messages returns the channel of the subscription's messages. It is
+// closed when the subscription is closed.
+func (sub *Subscription) Messages() <-chan Message {
+	return sub.messages
+}
+
+// Err returns ErrSlowConsumer if the subscription was closed for being
+// too slow.
+func (sub *Subscription) Err() error {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	return sub.err
+}
+
+// Close unsubscribes from all topics.
+func (sub *Subscription) Close() {
+	sub.close(nil)
+}
+
+func (sub *Subscription) close(err error) {
+	sub.mu.Lock()
+	if sub.closed {
+		sub.mu.Unlock()
+		return
+	}
+	sub.closed = true
+	sub.err = err
+	close(sub.messages)
+	sub.mu.Unlock()
+	if err == ErrSlowConsumer {
+		atomic.AddUint64(&sub.hub.disconnected, 1)
+	}
+	sub.hub.remove(sub)
+}
+
+type deliveryResult int
+
+const (
+	delivered deliveryResult = iota
+	dropped
+	slow
+)
+
+// deliver queues msg, applying policy if the buffer is full.
+func (sub *Subscription) deliver(msg Message, policy SlowConsumerPolicy) deliveryResult {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if sub.closed {
+		return dropped
+	}
+	select {
+	case sub.messages <- msg:
+		return delivered
+	default:
+	}
+	switch policy {
+	case DropOldest:
+		select {
+		case <-sub.messages:
+		default:
+		}
+		atomic.AddUint64(&sub.hub.dropped, 1)
+		select {
+		case sub.messages <- msg:
+			return delivered
+		default:
+		}
+	case Disconnect:
+		return slow
+	}
+	atomic.AddUint64(&sub.hub.dropped, 1)
+	return dropped
+}
@@ -0,0 +1,110 @@
+package web
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHubPublish(t *testing.T) {
+	hub := NewHub(HubConfig{})
+	news := hub.Subscribe("news")
+	all := hub.Subscribe("news", "sports")
+	defer news.Close()
+
+	if n := hub.Publish("news", "headline"); n != 2 {
+		t.Fatalf("Expected 2 receivers, got %d", n)
+	}
+	if n := hub.Publish("sports", "score"); n != 1 {
+		t.Fatalf("Expected 1 receiver, got %d", n)
+	}
+	if msg := <-news.Messages(); msg.Topic != "news" || msg.Data != "headline" {
+		t.Fatalf("Expected the headline, got %v", msg)
+	}
+	if msg := <-all.Messages(); msg.Data != "headline" {
+		t.Fatalf("Expected the headline, got %v", msg)
+	}
+	if msg := <-all.Messages(); msg.Topic != "sports" || msg.Data != "score" {
+		t.Fatalf("Expected the score, got %v", msg)
+	}
+
+	all.Close()
+	if _, ok := <-all.Messages(); ok {
+		t.Fatalf("Expected the messages of a closed subscription to end")
+	}
+	if n := hub.Subscribers("sports"); n != 0 {
+		t.Fatalf("Expected no sports subscribers, got %d", n)
+	}
+	stats := hub.Stats()
+	if stats.Subscribers != 1 || stats.Topics != 1 || stats.Published != 2 {
+		t.Fatalf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestHubSlowConsumer(t *testing.T) {
+	tests := []struct {
+		policy   SlowConsumerPolicy
+		received string
+		err      error
+	}{
+		{DropOldest, "[2 3]", nil},
+		{DropNewest, "[1 2]", nil},
+		{Disconnect, "[1 2]", ErrSlowConsumer},
+	}
+	for _, test := range tests {
+		hub := NewHub(HubConfig{BufferSize: 2, SlowConsumer: test.policy})
+		sub := hub.Subscribe("topic")
+		for i := 1; i <= 3; i++ {
+			hub.Publish("topic", i)
+		}
+		sub.Close()
+		var received []interface{}
+		for msg := range sub.Messages() {
+			received = append(received, msg.Data)
+		}
+		if fmt.Sprint(received) != test.received || sub.Err() != test.err {
+			t.Errorf("Policy %d: expected %s and %v, got %v and %v", test.policy, test.received, test.err, received, sub.Err())
+		}
+		if stats := hub.Stats(); stats.Subscribers != 0 || stats.Dropped+stats.Disconnected != 1 {
+			t.Errorf("Policy %d: unexpected stats %+v", test.policy, stats)
+		}
+	}
+}
+
+func TestContextSubscribe(t *testing.T) {
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	s.Get("/events", func(ctx *Context) {
+		sub := ctx.Subscribe("chat")
+		events, _ := ctx.SSE()
+		for msg := range sub.Messages() {
+			events.Send(Event{Data: msg.Data.(string)})
+			if msg.Data == "bye" {
+				return
+			}
+		}
+	})
+
+	rec := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		s.Process(rec, httptest.NewRequest("GET", "/events", nil))
+		close(done)
+	}()
+	for s.Hub().Subscribers("chat") == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	s.Hub().Publish("chat", "hello")
+	s.Hub().Publish("chat", "bye")
+	<-done
+
+	if rec.Body.String() != "data: hello\n\ndata: bye\n\n" {
+		t.Fatalf("Expected the published messages, got %q", rec.Body.String())
+	}
+	if n := s.Hub().Stats().Subscribers; n != 0 {
+		t.Fatalf("Expected the subscription to end with the request, got %d subscribers", n)
+	}
+}
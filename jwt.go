@@ -0,0 +1,310 @@
+package web
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"hash"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// A JWTAlgorithm is an HMAC algorithm for signing JSON Web Tokens.
+type JWTAlgorithm string
+
+const (
+	HS256 JWTAlgorithm = "HS256"
+	HS384 JWTAlgorithm = "HS384"
+	HS512 JWTAlgorithm = "HS512"
+)
+
+var (
+	ErrTokenMalformed   = errors.New("Token is malformed")
+	ErrTokenAlgorithm   = errors.New("Token uses an unexpected signing algorithm")
+	ErrTokenSignature   = errors.New("Token signature is invalid")
+	ErrTokenExpired     = errors.New("Token has expired")
+	ErrTokenNotYetValid = errors.New("Token is not valid yet")
+	ErrTokenIssuer      = errors.New("Token has an unexpected issuer")
+	ErrTokenAudience    = errors.New("Token is not intended for this audience")
+	ErrJWTKeyTooShort   = errors.New("Key is shorter than the hash of the signing algorithm")
+)
+
+var jwtEncoding = base64.RawURLEncoding
+
+// RegisteredClaims are the registered claims of RFC 7519. Custom claim
+// types embed it to be usable with VerifyJWT and as handler arguments.
+type RegisteredClaims struct {
+	Issuer    string      `json:"iss,omitempty"`
+	Subject   string      `json:"sub,omitempty"`
+	Audience  Audience    `json:"aud,omitempty"`
+	ExpiresAt NumericDate `json:"exp,omitempty"`
+	NotBefore NumericDate `json:"nbf,omitempty"`
+	IssuedAt  NumericDate `json:"iat,omitempty"`
+	ID        string      `json:"jti,omitempty"`
+}
+
+// NumericDate is a time in seconds since the Unix epoch. It may have a
+// fractional part.
+type NumericDate float64
+
+// NewNumericDate returns t as a NumericDate in whole seconds.
+func NewNumericDate(t time.Time) NumericDate {
+	return NumericDate(t.Unix())
+}
+
+// Time returns d as a time.Time.
+func (d NumericDate) Time() time.Time {
+	sec := math.Floor(float64(d))
+	return time.Unix(int64(sec), int64((float64(d)-sec)*1e9))
+}
+
+// Registered returns c. It makes every type embedding RegisteredClaims
+// implement JWTClaims.
+func (c *RegisteredClaims) Registered() *RegisteredClaims {
+	return c
+}
+
+// JWTClaims is implemented by pointers to claim types that embed
+// RegisteredClaims.
+type JWTClaims interface {
+	Registered() *RegisteredClaims
+}
+
+// Audience is the "aud" claim, which is either a single string or an
+// array of strings.
+type Audience []string
+
+// MarshalJSON encodes a single audience as a plain string.
+func (a Audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+// UnmarshalJSON accepts a string or an array of strings.
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(a))
+}
+
+func (alg JWTAlgorithm) hash() func() hash.Hash {
+	switch alg {
+	case HS256:
+		return sha256.New
+	case HS384:
+		return sha512.New384
+	case HS512:
+		return sha512.New
+	}
+	return nil
+}
+
+// checkKey rejects keys shorter than the output of the algorithm's hash,
+// as RFC 7518 requires. An empty key would let anyone sign tokens.
+func (alg JWTAlgorithm) checkKey(key []byte) error {
+	h := alg.hash()
+	if h == nil {
+		return ErrTokenAlgorithm
+	}
+	if len(key) < h().Size() {
+		return ErrJWTKeyTooShort
+	}
+	return nil
+}
+
+// SignJWT encodes claims as a JSON Web Token signed with key. The key must
+// be at least as long as the output of the algorithm's hash.
+func SignJWT(claims interface{}, alg JWTAlgorithm, key []byte) (string, error) {
+	if err := alg.checkKey(key); err != nil {
+		return "", err
+	}
+	h := alg.hash()
+	header, _ := json.Marshal(map[string]string{"alg": string(alg), "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	data := jwtEncoding.EncodeToString(header) + "." + jwtEncoding.EncodeToString(payload)
+	mac := hmac.New(h, key)
+	mac.Write([]byte(data))
+	return data + "." + jwtEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// JWTConfig configures the verification of JSON Web Tokens.
+type JWTConfig struct {
+	// Key must be at least as long as the output of the algorithm's hash,
+	// 32 bytes for HS256.
+	Key []byte
+	// Algorithm is the only algorithm accepted. It defaults to HS256.
+	Algorithm JWTAlgorithm
+	// Issuer and Audience, if set, must match the "iss" and "aud" claims.
+	Issuer   string
+	Audience string
+	// Leeway is the tolerated clock skew for the time based claims.
+	Leeway time.Duration
+	Realm  string
+}
+
+// VerifyJWT checks the signature and the registered claims of token and
+// decodes its claims into claims.
+func VerifyJWT(token string, claims JWTClaims, config JWTConfig) error {
+	payload, err := config.verify(token, time.Now())
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(payload, claims)
+}
+
+func (config *JWTConfig) algorithm() JWTAlgorithm {
+	if config.Algorithm == "" {
+		return HS256
+	}
+	return config.Algorithm
+}
+
+// verify checks token and returns its JSON payload.
+func (config *JWTConfig) verify(token string, now time.Time) ([]byte, error) {
+	alg := config.algorithm()
+	if err := alg.checkKey(config.Key); err != nil {
+		return nil, err
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrTokenMalformed
+	}
+	headerData, err := jwtEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrTokenMalformed
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if json.Unmarshal(headerData, &header) != nil {
+		return nil, ErrTokenMalformed
+	}
+	// only the configured algorithm is accepted, so a token can not
+	// downgrade its own verification
+	if header.Alg != string(alg) {
+		return nil, ErrTokenAlgorithm
+	}
+	sig, err := jwtEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrTokenMalformed
+	}
+	mac := hmac.New(alg.hash(), config.Key)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(mac.Sum(nil), sig) {
+		return nil, ErrTokenSignature
+	}
+
+	payload, err := jwtEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrTokenMalformed
+	}
+	var claims RegisteredClaims
+	if json.Unmarshal(payload, &claims) != nil {
+		return nil, ErrTokenMalformed
+	}
+	if err := config.validate(&claims, now); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// validate checks the time based claims, the issuer and the audience.
+func (config *JWTConfig) validate(claims *RegisteredClaims, now time.Time) error {
+	leeway := NumericDate(config.Leeway.Seconds())
+	unix := NumericDate(float64(now.UnixNano()) / 1e9)
+	if claims.ExpiresAt != 0 && unix >= claims.ExpiresAt+leeway {
+		return ErrTokenExpired
+	}
+	if claims.NotBefore != 0 && unix < claims.NotBefore-leeway {
+		return ErrTokenNotYetValid
+	}
+	if claims.IssuedAt != 0 && unix < claims.IssuedAt-leeway {
+		return ErrTokenNotYetValid
+	}
+	if config.Issuer != "" && claims.Issuer != config.Issuer {
+		return ErrTokenIssuer
+	}
+	if config.Audience != "" && !containsString(claims.Audience, config.Audience) {
+		return ErrTokenAudience
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// JWTAuth returns middleware that requires a valid JSON Web Token as
+// bearer token. The principal is named after the "sub" claim and gets the
+// roles of a "roles" claim. Handlers receive the verified claims by
+// declaring an argument of type *RegisteredClaims or a pointer to a type
+// embedding it. JWTAuth panics if the key is too short for the
+// algorithm.
+func JWTAuth(config JWTConfig) Middleware {
+	if err := config.algorithm().checkKey(config.Key); err != nil {
+		panic(err)
+	}
+	challenge := `Bearer realm=` + quoteAuthParam(config.Realm)
+	return func(ctx *Context, next func()) {
+		token, ok := ctx.bearerToken()
+		if !ok {
+			ctx.unauthorized(challenge)
+			return
+		}
+		payload, err := config.verify(token, time.Now())
+		if err != nil {
+			ctx.unauthorized(challenge + `, error="invalid_token", error_description=` + quoteAuthParam(err.Error()))
+			return
+		}
+
+		var claims struct {
+			RegisteredClaims
+			Roles []string `json:"roles"`
+		}
+		if err := json.Unmarshal(payload, &claims); err != nil {
+			ctx.unauthorized(challenge + `, error="invalid_token", error_description=` + quoteAuthParam(ErrTokenMalformed.Error()))
+			return
+		}
+		ctx.jwtPayload = payload
+		if ctx.principal == nil {
+			ctx.principal = &Principal{Name: claims.Subject, Scheme: "Bearer", Roles: claims.Roles}
+		}
+		next()
+	}
+}
+
+var jwtClaimsType = reflect.TypeOf((*JWTClaims)(nil)).Elem()
+
+// getJWTClaims injects the claims verified by JWTAuth into handlers. The
+// argument is nil if the request carried no verified token.
+func getJWTClaims(t reflect.Type, values []string, valueIndex int, ctx *Context) (reflect.Value, error) {
+	if t.Kind() != reflect.Ptr || !t.Implements(jwtClaimsType) {
+		return reflect.Value{}, NotSupported
+	}
+	if ctx == nil || ctx.jwtPayload == nil {
+		return reflect.Zero(t), NoValueNeeded
+	}
+	claims := reflect.New(t.Elem())
+	if err := json.Unmarshal(ctx.jwtPayload, claims.Interface()); err != nil {
+		return reflect.Zero(t), NoValueNeeded
+	}
+	return claims, NoValueNeeded
+}
@@ -0,0 +1,122 @@
+package web
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"io/ioutil"
+	"log"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testClaims struct {
+	RegisteredClaims
+	Name string `json:"name"`
+}
+
+var (
+	jwtTestKey  = strings.Repeat("secret", 11)
+	jwtOtherKey = strings.Repeat("other", 13)
+)
+
+func TestJWTVerify(t *testing.T) {
+	key := []byte(jwtTestKey)
+	now := time.Now()
+	config := JWTConfig{Key: key, Algorithm: HS512, Issuer: "web", Audience: "api", Leeway: 30 * time.Second}
+	valid := RegisteredClaims{Issuer: "web", Audience: Audience{"api", "admin"}, ExpiresAt: NewNumericDate(now.Add(time.Minute)), IssuedAt: NewNumericDate(now)}
+
+	token, err := SignJWT(&testClaims{valid, "foo"}, HS512, key)
+	if err != nil {
+		t.Fatalf("SignJWT failed: %v", err)
+	}
+	var claims testClaims
+	if err := VerifyJWT(token, &claims, config); err != nil || claims.Name != "foo" || claims.Audience[1] != "admin" {
+		t.Fatalf("Expected a valid token, got %v %#v", err, claims)
+	}
+
+	tests := []struct {
+		claims RegisteredClaims
+		alg    JWTAlgorithm
+		key    string
+		err    error
+	}{
+		{valid, HS256, jwtTestKey, ErrTokenAlgorithm},
+		{valid, HS512, jwtOtherKey, ErrTokenSignature},
+		{RegisteredClaims{Issuer: "web", Audience: Audience{"api"}, ExpiresAt: NewNumericDate(now.Add(-time.Minute))}, HS512, jwtTestKey, ErrTokenExpired},
+		{RegisteredClaims{Issuer: "web", Audience: Audience{"api"}, ExpiresAt: NewNumericDate(now.Add(-10 * time.Second))}, HS512, jwtTestKey, nil},
+		{RegisteredClaims{Issuer: "web", Audience: Audience{"api"}, NotBefore: NewNumericDate(now.Add(time.Minute))}, HS512, jwtTestKey, ErrTokenNotYetValid},
+		{RegisteredClaims{Issuer: "other", Audience: Audience{"api"}}, HS512, jwtTestKey, ErrTokenIssuer},
+		{RegisteredClaims{Issuer: "web", Audience: Audience{"web"}}, HS512, jwtTestKey, ErrTokenAudience},
+	}
+	for i, test := range tests {
+		token, _ := SignJWT(test.claims, test.alg, []byte(test.key))
+		if err := VerifyJWT(token, &RegisteredClaims{}, config); err != test.err {
+			t.Fatalf("Test %d: expected %v, got %v", i, test.err, err)
+		}
+	}
+
+	if err := VerifyJWT("a.b", &RegisteredClaims{}, config); err != ErrTokenMalformed {
+		t.Fatalf("Expected ErrTokenMalformed, got %v", err)
+	}
+
+	// NumericDates may have a fractional part
+	fractional := map[string]interface{}{"iss": "web", "aud": "api", "exp": float64(now.Add(time.Minute).UnixNano()) / 1e9}
+	token, _ = SignJWT(fractional, HS512, key)
+	if err := VerifyJWT(token, &claims, config); err != nil {
+		t.Fatalf("Expected a fractional expiry to be accepted, got %v", err)
+	}
+	if d := claims.ExpiresAt.Time().Sub(now.Add(time.Minute)); d < -time.Millisecond || d > time.Millisecond {
+		t.Fatalf("Expected the fractional expiry to be decoded, got %v", claims.ExpiresAt.Time())
+	}
+}
+
+func TestJWTShortKey(t *testing.T) {
+	if _, err := SignJWT(RegisteredClaims{Subject: "admin"}, HS256, nil); err != ErrJWTKeyTooShort {
+		t.Fatalf("Expected an empty key to be rejected, got %v", err)
+	}
+	if _, err := SignJWT(RegisteredClaims{}, HS512, make([]byte, 32)); err != ErrJWTKeyTooShort {
+		t.Fatalf("Expected a key shorter than the hash to be rejected, got %v", err)
+	}
+	// a token that anyone can sign with an empty key
+	data := jwtEncoding.EncodeToString([]byte(`{"alg":"HS256"}`)) + "." + jwtEncoding.EncodeToString([]byte(`{"sub":"admin"}`))
+	mac := hmac.New(sha256.New, nil)
+	mac.Write([]byte(data))
+	token := data + "." + jwtEncoding.EncodeToString(mac.Sum(nil))
+	if err := VerifyJWT(token, &RegisteredClaims{}, JWTConfig{}); err != ErrJWTKeyTooShort {
+		t.Fatalf("Expected the empty key to be rejected, got %v", err)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("Expected JWTAuth to reject an empty key")
+		}
+	}()
+	JWTAuth(JWTConfig{})
+}
+
+func TestJWTAuth(t *testing.T) {
+	key := []byte(jwtTestKey)
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	s.Use(JWTAuth(JWTConfig{Key: key, Realm: "api"}))
+	s.Get("/me", func(p *Principal, claims *testClaims) string {
+		return p.Name + " " + strings.Join(p.Roles, ",") + " " + claims.Name
+	})
+
+	token, _ := SignJWT(map[string]interface{}{"sub": "42", "name": "foo", "roles": []string{"admin"}}, HS256, key)
+	req := httptest.NewRequest("GET", "/me", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	s.Process(rec, req)
+	if rec.Code != 200 || rec.Body.String() != "42 admin foo" {
+		t.Fatalf("Expected the claims to be bound, got %d %q", rec.Code, rec.Body.String())
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token+"x")
+	rec = httptest.NewRecorder()
+	s.Process(rec, req)
+	if rec.Code != 401 || !strings.Contains(rec.Header().Get("WWW-Authenticate"), `error="invalid_token"`) {
+		t.Fatalf("Expected an invalid token to be rejected, got %d", rec.Code)
+	}
+}
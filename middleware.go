@@ -0,0 +1,44 @@
+package web
+
+// A Middleware runs before the handler of every matched route. It calls
+// next to continue with the next middleware and finally the handler. If it
+// does not call next, the request ends there and the middleware is
+// responsible for the response.
+type Middleware func(ctx *Context, next func())
+
+// Use adds middleware that runs for every route of server s.
+func (s *Server) Use(middleware ...Middleware) {
+	s.middleware = append(s.middleware, middleware...)
+}
+
+// Use adds middleware that only runs for route r, after the middleware of
+// the server and its groups.
+func (r *Route) Use(middleware ...Middleware) *Route {
+	r.middleware = append(r.middleware, middleware...)
+	return r
+}
+
+// runMiddleware runs the middleware of the server, the groups of route and
+// route itself, and then handler.
+func (s *Server) runMiddleware(ctx *Context, route *Route, handler func()) {
+	chain := s.middleware
+	if route.group != nil || len(route.middleware) > 0 {
+		chain = append([]Middleware{}, s.middleware...)
+		for _, g := range route.groups() {
+			chain = append(chain, g.middleware...)
+		}
+		chain = append(chain, route.middleware...)
+	}
+
+	i := 0
+	var next func()
+	next = func() {
+		if i < len(chain) {
+			i++
+			chain[i-1](ctx, next)
+		} else {
+			handler()
+		}
+	}
+	next()
+}
@@ -0,0 +1,128 @@
+package web
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"net/http"
+	"runtime/debug"
+	"sort"
+	"strings"
+)
+
+// A PanicHandler is called with the recovered value and the formatted stack
+// trace when a route panics and ServerConfig.RecoverPanic is set. It is
+// responsible for logging and for the response.
+type PanicHandler func(ctx *Context, err interface{}, stack []byte)
+
+// safely runs fn. If ServerConfig.RecoverPanic is set, a panic is passed to
+// the server's PanicHandler instead of crashing the server.
+func (s *Server) safely(ctx *Context, fn func()) {
+	defer func() {
+		if err := recover(); err != nil {
+			// ErrAbortHandler is the way to abort a response on purpose
+			if !s.Config.RecoverPanic || err == http.ErrAbortHandler {
+				// go back to panic
+				panic(err)
+			}
+			stack := debug.Stack()
+			if s.PanicHandler != nil {
+				s.PanicHandler(ctx, err, stack)
+			} else {
+				s.handlePanic(ctx, err, stack)
+			}
+		}
+	}()
+	fn()
+}
+
+// handlePanic logs the panic with a random error ID and replies with a
+// 500 page. In development mode the page shows the stack trace and the
+// details of the request. Otherwise it only shows the error ID, or the
+// error handler for 500 is called with the error ID as message.
+func (s *Server) handlePanic(ctx *Context, err interface{}, stack []byte) {
+	id := make([]byte, 8)
+	rand.Read(id)
+	errorID := hex.EncodeToString(id)
+	s.Logger.Printf("Handler crashed with error %v (error id %s)\n%s", err, errorID, stack)
+
+	if ctx.Written() {
+		// too late for an error page
+		return
+	}
+	// a crashing error handler falls back to the default page
+	if !s.Config.Development && ctx.errorStatus == 0 && s.errorHandler(ctx, 500) != nil {
+		ctx.Error(500, "Error ID: "+errorID)
+		return
+	}
+	page := panicPage{ErrorID: errorID}
+	if s.Config.Development {
+		page.Error = fmt.Sprint(err)
+		page.Stack = string(stack)
+		page.Request = ctx.Request
+		page.ClientIP = ctx.ClientIP()
+		if ctx.route != nil {
+			page.Route = ctx.route.method + " " + ctx.route.path
+		}
+		page.Headers = sortedValues(ctx.Request.Header)
+		page.Params = sortedValues(ctx.Params)
+	}
+	var body strings.Builder
+	panicTemplate.Execute(&body, page)
+	ctx.Abort(500, body.String())
+}
+
+type panicPage struct {
+	ErrorID  string
+	Error    string
+	Stack    string
+	Request  *http.Request
+	ClientIP string
+	Route    string
+	Headers  []string
+	Params   []string
+}
+
+// sortedValues formats the entries of a header or parameter map as sorted
+// "name: value" lines.
+func sortedValues(values interface{}) []string {
+	var lines []string
+	switch values := values.(type) {
+	case http.Header:
+		for k, v := range values {
+			lines = append(lines, k+": "+strings.Join(v, ", "))
+		}
+	case map[string]string:
+		for k, v := range values {
+			lines = append(lines, k+": "+v)
+		}
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+var panicTemplate = template.Must(template.New("panic").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Server Error</title></head>
+<body>
+<h1>Server Error</h1>
+<p>Error ID: {{.ErrorID}}</p>
+{{- if .Request}}
+<h2>{{.Error}}</h2>
+<pre>{{.Stack}}</pre>
+<h2>Request</h2>
+<p>{{.Request.Method}} {{.Request.URL}} from {{.ClientIP}}</p>
+{{- if .Route}}
+<p>Route: {{.Route}}</p>
+{{- end}}
+<h3>Headers</h3>
+<pre>{{range .Headers}}{{.}}
+{{end}}</pre>
+<h3>Parameters</h3>
+<pre>{{range .Params}}{{.}}
+{{end}}</pre>
+{{- end}}
+</body>
+</html>
+`))
@@ -0,0 +1,94 @@
+package web
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func newPanicTestServer(development bool) (*Server, *bytes.Buffer) {
+	s := NewServer()
+	var logOutput bytes.Buffer
+	s.SetLogger(log.New(&logOutput, "", 0))
+	s.Config = &ServerConfig{RecoverPanic: true, Development: development}
+	s.Get("/panic/([a-z]+)", func(name string) string { panic("broken " + name) })
+	s.Get("/partial", func(ctx *Context) {
+		ctx.WriteString("partial")
+		panic("late")
+	})
+	return s, &logOutput
+}
+
+var errorIDRegex = regexp.MustCompile(`Error ID: ([0-9a-f]{16})`)
+
+func TestPanicProduction(t *testing.T) {
+	s, logOutput := newPanicTestServer(false)
+	rec := httptest.NewRecorder()
+	s.Process(rec, httptest.NewRequest("GET", "/panic/item?secret=1", nil))
+	body := rec.Body.String()
+	if rec.Code != 500 || !strings.Contains(body, "Server Error") {
+		t.Fatalf("Expected generic 500 page, got %d %q", rec.Code, body)
+	}
+	match := errorIDRegex.FindStringSubmatch(body)
+	if match == nil {
+		t.Fatalf("Expected an error ID in %q", body)
+	}
+	if strings.Contains(body, "broken") || strings.Contains(body, "secret") {
+		t.Fatalf("Expected no details in production, got %q", body)
+	}
+	log := logOutput.String()
+	if !strings.Contains(log, "broken item (error id "+match[1]+")") || !strings.Contains(log, "panic_test.go") {
+		t.Fatalf("Expected the error and stack with the error ID in the log, got %q", log)
+	}
+
+	// a started response is not replaced
+	rec = httptest.NewRecorder()
+	s.Process(rec, httptest.NewRequest("GET", "/partial", nil))
+	if rec.Code != 200 || rec.Body.String() != "partial" {
+		t.Fatalf("Expected the partial response, got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestPanicDevelopment(t *testing.T) {
+	s, _ := newPanicTestServer(true)
+	req := httptest.NewRequest("GET", "/panic/item?q=<b>", nil)
+	req.Header.Set("X-Test", "yes")
+	rec := httptest.NewRecorder()
+	s.Process(rec, req)
+	body := rec.Body.String()
+	for _, expected := range []string{
+		"<h2>broken item</h2>",
+		"panic_test.go",
+		"GET /panic/item?q=&lt;b&gt; from 192.0.2.1",
+		"Route: GET /panic/([a-z]&#43;)",
+		"X-Test: yes",
+		"q: &lt;b&gt;",
+	} {
+		if !strings.Contains(body, expected) {
+			t.Fatalf("Expected %q in the development page, got %q", expected, body)
+		}
+	}
+}
+
+func TestPanicHandler(t *testing.T) {
+	s, _ := newPanicTestServer(false)
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	var recovered interface{}
+	var stack []byte
+	s.PanicHandler = func(ctx *Context, err interface{}, st []byte) {
+		recovered, stack = err, st
+		ctx.Abort(503, "custom")
+	}
+	rec := httptest.NewRecorder()
+	s.Process(rec, httptest.NewRequest("GET", "/panic/item", nil))
+	if rec.Code != 503 || rec.Body.String() != "custom" {
+		t.Fatalf("Expected the custom response, got %d %q", rec.Code, rec.Body.String())
+	}
+	if recovered != "broken item" || !bytes.Contains(stack, []byte("panic_test.go")) {
+		t.Fatalf("Expected the panic and its stack, got %v %q", recovered, stack)
+	}
+}
@@ -0,0 +1,186 @@
+package web
+
+import (
+	"net"
+	"strings"
+)
+
+// trustedProxies returns the parsed ServerConfig.TrustedProxies. They are
+// parsed once, when first needed; invalid entries are logged and ignored.
+func (s *Server) trustedProxies() []*net.IPNet {
+	s.proxiesOnce.Do(func() {
+		s.proxies = s.parseNetworks(s.Config.TrustedProxies, "trusted proxy")
+	})
+	return s.proxies
+}
+
+// proxyProtocolPeers returns the parsed ServerConfig.ProxyProtocolPeers,
+// like trustedProxies.
+func (s *Server) proxyProtocolPeers() []*net.IPNet {
+	s.peersOnce.Do(func() {
+		s.peers = s.parseNetworks(s.Config.ProxyProtocolPeers, "PROXY protocol peer")
+	})
+	return s.peers
+}
+
+// parseNetworks parses addresses and CIDR ranges. Invalid entries are
+// logged as the given kind and ignored.
+func (s *Server) parseNetworks(entries []string, kind string) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, entry := range entries {
+		if !strings.Contains(entry, "/") {
+			if strings.Contains(entry, ":") {
+				entry += "/128"
+			} else {
+				entry += "/32"
+			}
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			s.Logger.Printf("Invalid %s %q\n", kind, entry)
+			continue
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// isTrustedProxy reports whether the address ip belongs to a trusted proxy.
+func (s *Server) isTrustedProxy(ip string) bool {
+	return containsIP(s.trustedProxies(), ip)
+}
+
+// containsIP reports whether the address ip belongs to one of networks.
+func containsIP(networks []*net.IPNet, ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// hostOnly strips the port and the brackets of IPv6 addresses from addr.
+func hostOnly(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+}
+
+// forwardedHop is a proxy hop as described by a Forwarded element or the
+// X-Forwarded-* headers.
+type forwardedHop struct {
+	client string
+	proto  string
+	host   string
+}
+
+// forwardedHops returns the hops recorded by proxies, the one closest to
+// the client first. The Forwarded header takes precedence over the
+// X-Forwarded-* headers.
+func forwardedHops(ctx *Context) []forwardedHop {
+	header := ctx.Request.Header
+	var hops []forwardedHop
+	if values := header["Forwarded"]; len(values) > 0 {
+		for _, element := range strings.Split(strings.Join(values, ","), ",") {
+			var hop forwardedHop
+			for _, pair := range strings.Split(element, ";") {
+				kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+				if len(kv) != 2 {
+					continue
+				}
+				value := strings.Trim(kv[1], `"`)
+				switch strings.ToLower(kv[0]) {
+				case "for":
+					hop.client = hostOnly(value)
+				case "proto":
+					hop.proto = strings.ToLower(value)
+				case "host":
+					hop.host = value
+				}
+			}
+			hops = append(hops, hop)
+		}
+		return hops
+	}
+
+	for _, client := range strings.Split(strings.Join(header["X-Forwarded-For"], ","), ",") {
+		if client = strings.TrimSpace(client); client != "" {
+			hops = append(hops, forwardedHop{client: hostOnly(client)})
+		}
+	}
+	if len(hops) == 0 {
+		hops = append(hops, forwardedHop{})
+	}
+	// the proxies set a single scheme and host for the original request,
+	// which applies to every hop; if several were appended, the last one
+	// comes from the nearest proxy
+	proto := strings.ToLower(lastListValue(header.Get("X-Forwarded-Proto")))
+	host := lastListValue(header.Get("X-Forwarded-Host"))
+	for i := range hops {
+		hops[i].proto = proto
+		hops[i].host = host
+	}
+	return hops
+}
+
+func lastListValue(value string) string {
+	if pos := strings.LastIndexByte(value, ','); pos >= 0 {
+		value = value[pos+1:]
+	}
+	return strings.TrimSpace(value)
+}
+
+// originHop returns the hop describing the original request: the one
+// added by the outermost trusted proxy. ok is false if the request did not
+// come through a trusted proxy.
+func (ctx *Context) originHop() (hop forwardedHop, ok bool) {
+	if !ctx.Server.isTrustedProxy(hostOnly(ctx.Request.RemoteAddr)) {
+		return hop, false
+	}
+	hops := forwardedHops(ctx)
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop = hops[i]
+		if i == 0 || !ctx.Server.isTrustedProxy(hop.client) {
+			break
+		}
+	}
+	return hop, true
+}
+
+// ClientIP returns the address of the client. For requests from a trusted
+// proxy (see ServerConfig.TrustedProxies) it is taken from the Forwarded
+// or X-Forwarded-For header, skipping further trusted proxies.
+func (ctx *Context) ClientIP() string {
+	if hop, ok := ctx.originHop(); ok && hop.client != "" {
+		return hop.client
+	}
+	return hostOnly(ctx.Request.RemoteAddr)
+}
+
+// Scheme returns the scheme of the original request, "http" or "https".
+// For requests from a trusted proxy it is taken from the Forwarded or
+// X-Forwarded-Proto header.
+func (ctx *Context) Scheme() string {
+	if hop, ok := ctx.originHop(); ok && (hop.proto == "http" || hop.proto == "https") {
+		return hop.proto
+	}
+	if ctx.Request.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// Host returns the host of the original request. For requests from a
+// trusted proxy it is taken from the Forwarded or X-Forwarded-Host header.
+func (ctx *Context) Host() string {
+	if hop, ok := ctx.originHop(); ok && hop.host != "" {
+		return hop.host
+	}
+	return ctx.Request.Host
+}
@@ -0,0 +1,199 @@
+package web
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultProxyHeaderTimeout is the time a trusted peer has to send its
+// PROXY protocol header if ProxyListener.HeaderTimeout is zero.
+const DefaultProxyHeaderTimeout = 10 * time.Second
+
+var errProxyHeader = errors.New("Invalid PROXY protocol header")
+
+// proxyV2Signature starts every PROXY protocol version 2 header.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// ProxyListener accepts connections that start with a PROXY protocol
+// version 1 or 2 header, as sent by HAProxy and many load balancers. The
+// addresses of the header become the RemoteAddr and LocalAddr of the
+// connection. The header is required on connections from trusted peers,
+// which fail on the first read without one, and is not parsed on other
+// connections.
+type ProxyListener struct {
+	net.Listener
+	// Trusted reports whether the peer at addr may send a PROXY header.
+	Trusted func(addr net.Addr) bool
+	// HeaderTimeout limits the time for reading the header.
+	HeaderTimeout time.Duration
+}
+
+// Accept waits for the next connection. Its header is read on first use
+// of the connection, so that a slow peer does not block Accept.
+func (l *ProxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if l.Trusted == nil || !l.Trusted(conn.RemoteAddr()) {
+		return conn, nil
+	}
+	timeout := l.HeaderTimeout
+	if timeout == 0 {
+		timeout = DefaultProxyHeaderTimeout
+	}
+	return &proxyConn{Conn: conn, reader: bufio.NewReader(conn), timeout: timeout}, nil
+}
+
+// proxyConn is a connection from a trusted peer.
+type proxyConn struct {
+	net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+	once    sync.Once
+	remote  net.Addr
+	local   net.Addr
+	err     error
+}
+
+func (c *proxyConn) init() {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		c.err = c.readHeader()
+		c.Conn.SetReadDeadline(time.Time{})
+	})
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.init()
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyConn) LocalAddr() net.Addr {
+	c.init()
+	if c.local != nil {
+		return c.local
+	}
+	return c.Conn.LocalAddr()
+}
+
+// readHeader consumes the PROXY header. Guessing whether a header is
+// present would let clients whose bytes a peer forwards send their own.
+func (c *proxyConn) readHeader() error {
+	first, err := c.reader.Peek(1)
+	if err != nil {
+		return err
+	}
+	switch first[0] {
+	case 'P':
+		if prefix, err := c.reader.Peek(6); err == nil && string(prefix) == "PROXY " {
+			return c.readV1()
+		}
+	case '\r':
+		if prefix, err := c.reader.Peek(len(proxyV2Signature)); err == nil && bytes.Equal(prefix, proxyV2Signature) {
+			return c.readV2()
+		}
+	}
+	return errProxyHeader
+}
+
+// readV1 parses a header like "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443".
+func (c *proxyConn) readV1() error {
+	// the longest valid header has 107 bytes
+	var line []byte
+	for len(line) < 107 {
+		b, err := c.reader.ReadByte()
+		if err != nil {
+			return err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return errProxyHeader
+	}
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return errProxyHeader
+	}
+	src, dst := net.ParseIP(fields[2]), net.ParseIP(fields[3])
+	srcPort, err1 := strconv.ParseUint(fields[4], 10, 16)
+	dstPort, err2 := strconv.ParseUint(fields[5], 10, 16)
+	if src == nil || dst == nil || err1 != nil || err2 != nil {
+		return errProxyHeader
+	}
+	c.remote = &net.TCPAddr{IP: src, Port: int(srcPort)}
+	c.local = &net.TCPAddr{IP: dst, Port: int(dstPort)}
+	return nil
+}
+
+// readV2 parses a binary header. Type-length-value extensions are skipped.
+func (c *proxyConn) readV2() error {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(c.reader, header); err != nil {
+		return err
+	}
+	if header[12]>>4 != 2 {
+		return errProxyHeader
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:]))
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return err
+	}
+
+	switch header[12] & 0xf {
+	case 0:
+		// LOCAL: a health check of the proxy itself
+		return nil
+	case 1:
+	default:
+		return errProxyHeader
+	}
+
+	var size int
+	switch header[13] >> 4 {
+	case 1:
+		size = net.IPv4len
+	case 2:
+		size = net.IPv6len
+	default:
+		// UNSPEC and unix sockets keep the addresses of the connection
+		return nil
+	}
+	if len(payload) < 2*size+4 {
+		return errProxyHeader
+	}
+	c.remote = &net.TCPAddr{
+		IP:   net.IP(payload[:size]),
+		Port: int(binary.BigEndian.Uint16(payload[2*size:])),
+	}
+	c.local = &net.TCPAddr{
+		IP:   net.IP(payload[size : 2*size]),
+		Port: int(binary.BigEndian.Uint16(payload[2*size+2:])),
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package web
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// pipeListener hands out the server ends of net.Pipe connections.
+type pipeListener chan net.Conn
+
+func (l pipeListener) Accept() (net.Conn, error) { return <-l, nil }
+func (l pipeListener) Close() error              { return nil }
+func (l pipeListener) Addr() net.Addr            { return &net.TCPAddr{} }
+
+func proxyV2Header(command byte, family byte, addrs []byte) []byte {
+	header := append([]byte{}, proxyV2Signature...)
+	header = append(header, 0x20|command, family, 0, 0)
+	binary.BigEndian.PutUint16(header[14:], uint16(len(addrs)))
+	return append(header, addrs...)
+}
+
+func TestProxyListener(t *testing.T) {
+	v4 := []byte{192, 0, 2, 1, 198, 51, 100, 1, 0xdc, 0x04, 0x01, 0xbb}
+	v6 := make([]byte, 36)
+	v6[0], v6[1], v6[15] = 0x20, 0x01, 1
+	v6[31] = 2
+	binary.BigEndian.PutUint16(v6[32:], 4711)
+	binary.BigEndian.PutUint16(v6[34:], 443)
+
+	tests := []struct {
+		trusted bool
+		header  string
+		remote  string
+		local   string
+		valid   bool
+	}{
+		{true, "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n", "192.0.2.1:56324", "198.51.100.1:443", true},
+		{true, "PROXY TCP6 2001:db8::1 2001:db8::2 4711 443\r\n", "[2001:db8::1]:4711", "[2001:db8::2]:443", true},
+		{true, "PROXY UNKNOWN\r\n", "pipe", "pipe", true},
+		{true, string(proxyV2Header(1, 0x11, v4)), "192.0.2.1:56324", "198.51.100.1:443", true},
+		{true, string(proxyV2Header(1, 0x21, append(v6, 0x04, 0, 1, 'x'))), "[2001::1]:4711", "[::2]:443", true},
+		{true, string(proxyV2Header(0, 0x00, nil)), "pipe", "pipe", true},
+		// the header is required from trusted peers
+		{true, "", "pipe", "pipe", false},
+		{true, "PROXY TCP4 192.0.2.1 198.51.100.1 56324\r\n", "pipe", "pipe", false},
+		{true, "PROXY TCP4 192.0.2.1 nonsense 56324 443\r\n", "pipe", "pipe", false},
+		{true, string(proxyV2Header(1, 0x11, v4[:8])), "pipe", "pipe", false},
+		// untrusted peers can not set the addresses
+		{false, "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n", "pipe", "pipe", false},
+	}
+
+	for i, test := range tests {
+		client, server := net.Pipe()
+		conns := make(pipeListener, 1)
+		conns <- server
+		l := &ProxyListener{
+			Listener:      conns,
+			Trusted:       func(net.Addr) bool { return test.trusted },
+			HeaderTimeout: time.Second,
+		}
+		conn, _ := l.Accept()
+		go func() {
+			client.Write([]byte(test.header + "GET / HTTP/1.0\r\n"))
+			client.Close()
+		}()
+
+		if got := conn.RemoteAddr().String(); got != test.remote {
+			t.Errorf("Test %d: expected remote address %q, got %q", i, test.remote, got)
+		}
+		if got := conn.LocalAddr().String(); got != test.local {
+			t.Errorf("Test %d: expected local address %q, got %q", i, test.local, got)
+		}
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		if valid := err == nil && line == "GET / HTTP/1.0\r\n"; valid != test.valid {
+			t.Errorf("Test %d: expected valid %v, read %q (%v)", i, test.valid, line, err)
+		}
+		conn.Close()
+	}
+}
+
+func TestServeProxyProtocol(t *testing.T) {
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	// trusting the peer's HTTP headers does not make it a PROXY protocol peer
+	s.Config = &ServerConfig{TrustedProxies: []string{"127.0.0.1"}}
+	if l := s.proxyListener(pipeListener(nil)); l != (pipeListener(nil)) {
+		t.Fatalf("Expected no PROXY protocol without ProxyProtocolPeers")
+	}
+	s.Config = &ServerConfig{ProxyProtocolPeers: []string{"127.0.0.1"}}
+	s.Get("/", func(ctx *Context) string { return ctx.ClientIP() })
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go s.Serve(l)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("PROXY TCP4 203.0.113.9 127.0.0.1 40000 80\r\nGET / HTTP/1.0\r\nHost: example.com\r\n\r\n"))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	if string(body) != "203.0.113.9" {
+		t.Fatalf("Expected client 203.0.113.9, got %q", body)
+	}
+}
@@ -0,0 +1,98 @@
+package web
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"log"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestForwardedRequests(t *testing.T) {
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	s.Config = &ServerConfig{TrustedProxies: []string{"10.0.0.0/8", "fd00::1"}}
+
+	tests := []struct {
+		remote  string
+		headers map[string]string
+		tls     bool
+		ip      string
+		scheme  string
+		host    string
+	}{
+		// untrusted peers can not spoof the headers
+		{"192.0.2.1:1234", map[string]string{"X-Forwarded-For": "203.0.113.9", "X-Forwarded-Proto": "https"}, false, "192.0.2.1", "http", "example.com"},
+		{"[2001:db8::1]:1234", nil, true, "2001:db8::1", "https", "example.com"},
+		{"10.0.0.1:1234", nil, false, "10.0.0.1", "http", "example.com"},
+		{"10.0.0.1:1234", map[string]string{"X-Forwarded-For": "203.0.113.9", "X-Forwarded-Proto": "https", "X-Forwarded-Host": "www.example.com"}, false, "203.0.113.9", "https", "www.example.com"},
+		// the scheme and host also apply if the client sent its own hops
+		{"10.0.0.1:1234", map[string]string{"X-Forwarded-For": "1.1.1.1, 203.0.113.9", "X-Forwarded-Proto": "https", "X-Forwarded-Host": "www.example.com"}, false, "203.0.113.9", "https", "www.example.com"},
+		{"10.0.0.1:1234", map[string]string{"X-Forwarded-For": "203.0.113.9, 10.1.1.1", "X-Forwarded-Proto": "https", "X-Forwarded-Host": "www.example.com"}, false, "203.0.113.9", "https", "www.example.com"},
+		// trusted hops are skipped, spoofed entries before the first
+		// untrusted hop are ignored
+		{"10.0.0.1:1234", map[string]string{"X-Forwarded-For": "198.51.100.7, 203.0.113.9, 10.1.1.1"}, false, "203.0.113.9", "http", "example.com"},
+		{"10.0.0.1:1234", map[string]string{"X-Forwarded-For": "10.2.2.2, 10.1.1.1"}, false, "10.2.2.2", "http", "example.com"},
+		{"10.0.0.1:1234", map[string]string{"X-Forwarded-Proto": "https, http"}, false, "10.0.0.1", "http", "example.com"},
+		{"[fd00::1]:1234", map[string]string{"Forwarded": `for="[2001:db8::2]:4711";proto=https;host=www.example.com`}, false, "2001:db8::2", "https", "www.example.com"},
+		{"10.0.0.1:1234", map[string]string{"Forwarded": `for=198.51.100.7;proto=http, for=203.0.113.9;proto=https;host=a.example.com, for=10.1.1.1;proto=http;host=internal`}, false, "203.0.113.9", "https", "a.example.com"},
+		// Forwarded takes precedence
+		{"10.0.0.1:1234", map[string]string{"Forwarded": "for=203.0.113.9", "X-Forwarded-For": "198.51.100.7"}, false, "203.0.113.9", "http", "example.com"},
+		{"10.0.0.1:1234", map[string]string{"Forwarded": "for=unknown;proto=ftp"}, true, "unknown", "https", "example.com"},
+	}
+
+	for i, test := range tests {
+		req := httptest.NewRequest("GET", "http://example.com/", nil)
+		req.RemoteAddr = test.remote
+		if test.tls {
+			req.TLS = &tls.ConnectionState{}
+		}
+		for k, v := range test.headers {
+			req.Header.Set(k, v)
+		}
+		ctx := &Context{Request: req, Server: s}
+		if got := ctx.ClientIP(); got != test.ip {
+			t.Errorf("Test %d: expected client %q, got %q", i, test.ip, got)
+		}
+		if got := ctx.Scheme(); got != test.scheme {
+			t.Errorf("Test %d: expected scheme %q, got %q", i, test.scheme, got)
+		}
+		if got := ctx.Host(); got != test.host {
+			t.Errorf("Test %d: expected host %q, got %q", i, test.host, got)
+		}
+	}
+}
+
+func TestForwardedRedirect(t *testing.T) {
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	s.Config = &ServerConfig{TrustedProxies: []string{"10.0.0.0/8"}, AllowedHosts: []string{"www.example.com", "internal"}}
+	s.Get("/old", func(ctx *Context) { ctx.Redirect(302, "/new") })
+	s.Get("/away", func(ctx *Context) { ctx.Redirect(302, "//other.example.com/") })
+
+	req := httptest.NewRequest("GET", "http://internal:8080/old", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-Proto", "https")
+	req.Header.Set("X-Forwarded-Host", "www.example.com")
+	rec := httptest.NewRecorder()
+	s.Process(rec, req)
+	if got := rec.Header().Get("Location"); got != "https://www.example.com/new" {
+		t.Fatalf("Expected absolute redirect, got %q", got)
+	}
+
+	req = httptest.NewRequest("GET", "http://internal:8080/away", nil)
+	rec = httptest.NewRecorder()
+	s.Process(rec, req)
+	if got := rec.Header().Get("Location"); got != "//other.example.com/" {
+		t.Fatalf("Expected protocol relative redirect unchanged, got %q", got)
+	}
+
+	// without validated hosts, the Host header could inject any host
+	s.Config.AllowedHosts = nil
+	req = httptest.NewRequest("GET", "http://evil.com/old", nil)
+	rec = httptest.NewRecorder()
+	s.Process(rec, req)
+	if got := rec.Header().Get("Location"); got != "/new" {
+		t.Fatalf("Expected relative redirect without allowed hosts, got %q", got)
+	}
+}
@@ -0,0 +1,266 @@
+package web
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimitAlgorithm selects how requests are counted.
+type RateLimitAlgorithm int
+
+const (
+	// TokenBucket allows bursts of up to Limit requests and refills the
+	// bucket at Limit requests per Window.
+	TokenBucket RateLimitAlgorithm = iota
+	// SlidingWindow allows Limit requests in any period of length Window,
+	// approximated by weighting the previous fixed window.
+	SlidingWindow
+)
+
+// A RateLimitKeyFunc returns the key that a request is counted under.
+// Requests with an empty key are not limited.
+type RateLimitKeyFunc func(ctx *Context) string
+
+// RateLimitConfig configures the RateLimit middleware.
+type RateLimitConfig struct {
+	Algorithm RateLimitAlgorithm
+	// Limit and Window must be positive.
+	Limit  int
+	Window time.Duration
+	// Key selects what is limited. It defaults to KeyByIP.
+	Key RateLimitKeyFunc
+	// Store holds the counters. It defaults to a MemoryStore that evicts
+	// keys idle for longer than twice the window.
+	Store RateLimitStore
+}
+
+// RateLimitState is the per key state of the rate limiting algorithms.
+type RateLimitState struct {
+	Tokens    float64
+	Count     int
+	PrevCount int
+	Start     time.Time
+	Last      time.Time
+}
+
+// A RateLimitStore holds the state of all rate limiting keys.
+type RateLimitStore interface {
+	// Update calls fn with the state of key, which is the zero value for
+	// a new key. No other call for the same key may run concurrently.
+	Update(key string, fn func(state *RateLimitState))
+}
+
+// MemoryStore is a RateLimitStore that keeps the states in memory. Keys
+// that have not been used for the idle duration are evicted.
+type MemoryStore struct {
+	idle      time.Duration
+	mu        sync.Mutex
+	states    map[string]*RateLimitState
+	lastSweep time.Time
+}
+
+// NewMemoryStore returns a MemoryStore that evicts keys after idle.
+func NewMemoryStore(idle time.Duration) *MemoryStore {
+	return &MemoryStore{idle: idle, states: map[string]*RateLimitState{}}
+}
+
+// Update calls fn with the state of key.
+func (m *MemoryStore) Update(key string, fn func(state *RateLimitState)) {
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if now.Sub(m.lastSweep) > m.idle {
+		for k, state := range m.states {
+			if now.Sub(state.Last) > m.idle {
+				delete(m.states, k)
+			}
+		}
+		m.lastSweep = now
+	}
+
+	state, ok := m.states[key]
+	if !ok {
+		state = &RateLimitState{}
+		m.states[key] = state
+	}
+	fn(state)
+	state.Last = now
+}
+
+// Len returns the number of keys in the store.
+func (m *MemoryStore) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.states)
+}
+
+// KeyByIP limits requests per client address, as returned by
+// Context.ClientIP.
+func KeyByIP(ctx *Context) string {
+	return ctx.ClientIP()
+}
+
+// KeyByRoute limits requests per route, shared by all clients.
+func KeyByRoute(ctx *Context) string {
+	if ctx.route == nil {
+		return ""
+	}
+	return ctx.route.method + " " + ctx.route.path
+}
+
+// KeyByUser limits requests per authenticated principal, so it must run
+// after the authentication middleware. Anonymous requests, including those
+// with credentials that were not verified, are limited per client address
+// like KeyByIP.
+func KeyByUser(ctx *Context) string {
+	if p := ctx.Principal(); p != nil {
+		return "user:" + p.Name
+	}
+	return KeyByIP(ctx)
+}
+
+// KeyByHeader limits requests per value of the header name, for example
+// an API key. Requests without the header are not limited.
+func KeyByHeader(name string) RateLimitKeyFunc {
+	return func(ctx *Context) string {
+		if value := ctx.Request.Header.Get(name); value != "" {
+			return name + ":" + value
+		}
+		return ""
+	}
+}
+
+// KeyByParam limits requests per value of the request parameter name, for
+// example an API key passed in the query string.
+func KeyByParam(name string) RateLimitKeyFunc {
+	return func(ctx *Context) string {
+		if value := ctx.Params[name]; value != "" {
+			return name + "=" + value
+		}
+		return ""
+	}
+}
+
+// JoinKeys combines several key functions, for example to limit requests
+// per client and route. The request is not limited if any key is empty.
+func JoinKeys(keys ...RateLimitKeyFunc) RateLimitKeyFunc {
+	return func(ctx *Context) string {
+		var joined string
+		for i, key := range keys {
+			k := key(ctx)
+			if k == "" {
+				return ""
+			}
+			if i > 0 {
+				joined += "|"
+			}
+			joined += k
+		}
+		return joined
+	}
+}
+
+// RateLimit returns middleware that rejects requests exceeding the limit
+// of config with 429 Too Many Requests and a Retry-After header. Allowed
+// requests get RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset
+// headers. RateLimit panics if Limit or Window is not positive, which
+// would silently disable the limit.
+func RateLimit(config RateLimitConfig) Middleware {
+	if config.Limit <= 0 || config.Window <= 0 {
+		panic(fmt.Sprintf("Rate limit of %d requests per %v is invalid", config.Limit, config.Window))
+	}
+	if config.Key == nil {
+		config.Key = KeyByIP
+	}
+	if config.Store == nil {
+		config.Store = NewMemoryStore(2 * config.Window)
+	}
+
+	return func(ctx *Context, next func()) {
+		key := config.Key(ctx)
+		if key == "" {
+			next()
+			return
+		}
+
+		var allowed bool
+		var remaining int
+		var reset time.Duration
+		now := time.Now()
+		config.Store.Update(key, func(state *RateLimitState) {
+			if config.Algorithm == SlidingWindow {
+				allowed, remaining, reset = config.slidingWindow(state, now)
+			} else {
+				allowed, remaining, reset = config.tokenBucket(state, now)
+			}
+		})
+
+		header := ctx.ResponseWriter.Header()
+		header.Set("RateLimit-Limit", strconv.Itoa(config.Limit))
+		header.Set("RateLimit-Remaining", strconv.Itoa(remaining))
+		header.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(reset)))
+		if !allowed {
+			header.Set("Retry-After", strconv.Itoa(ceilSeconds(reset)))
+			ctx.Error(429, "Too Many Requests")
+			return
+		}
+		next()
+	}
+}
+
+// tokenBucket takes a token from the bucket. reset is the time until the
+// next token is available if the request is rejected, or until the bucket
+// is full otherwise.
+func (config *RateLimitConfig) tokenBucket(state *RateLimitState, now time.Time) (bool, int, time.Duration) {
+	limit := float64(config.Limit)
+	rate := limit / config.Window.Seconds()
+	if state.Start.IsZero() {
+		state.Tokens = limit
+	} else {
+		state.Tokens = math.Min(limit, state.Tokens+now.Sub(state.Start).Seconds()*rate)
+	}
+	state.Start = now
+
+	if state.Tokens < 1 {
+		return false, 0, secondsDuration((1 - state.Tokens) / rate)
+	}
+	state.Tokens--
+	return true, int(state.Tokens), secondsDuration((limit - state.Tokens) / rate)
+}
+
+// slidingWindow counts the request in the current window. The previous
+// window's count is weighted by how much of it overlaps the sliding window.
+func (config *RateLimitConfig) slidingWindow(state *RateLimitState, now time.Time) (bool, int, time.Duration) {
+	start := now.Truncate(config.Window)
+	if !state.Start.Equal(start) {
+		if state.Start.Add(config.Window).Equal(start) {
+			state.PrevCount = state.Count
+		} else {
+			state.PrevCount = 0
+		}
+		state.Count = 0
+		state.Start = start
+	}
+
+	elapsed := now.Sub(start)
+	weight := 1 - float64(elapsed)/float64(config.Window)
+	used := int(math.Floor(float64(state.PrevCount)*weight)) + state.Count
+	reset := config.Window - elapsed
+	if used >= config.Limit {
+		return false, 0, reset
+	}
+	state.Count++
+	return true, config.Limit - used - 1, reset
+}
+
+func secondsDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,135 @@
+package web
+
+import (
+	"io/ioutil"
+	"log"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRateLimitTokenBucket(t *testing.T) {
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	s.Post("/login", func() string { return "ok" }).Use(RateLimit(RateLimitConfig{Limit: 3, Window: time.Minute}))
+	s.Get("/free", func() string { return "ok" })
+
+	for i := 0; i < 3; i++ {
+		rec := httptest.NewRecorder()
+		s.Process(rec, httptest.NewRequest("POST", "/login", nil))
+		if rec.Code != 200 {
+			t.Fatalf("Request %d: expected 200, got %d", i, rec.Code)
+		}
+		if rec.Header().Get("RateLimit-Remaining") != strconv.Itoa(2-i) {
+			t.Fatalf("Request %d: unexpected RateLimit-Remaining %q", i, rec.Header().Get("RateLimit-Remaining"))
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	s.Process(rec, httptest.NewRequest("POST", "/login", nil))
+	if rec.Code != 429 || rec.Header().Get("Retry-After") != "20" {
+		t.Fatalf("Expected 429 with Retry-After 20, got %d and %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	req := httptest.NewRequest("POST", "/login", nil)
+	req.RemoteAddr = "[2001:db8::1]:1234"
+	rec = httptest.NewRecorder()
+	s.Process(rec, req)
+	if rec.Code != 200 {
+		t.Fatalf("Expected another client to be allowed, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	s.Process(rec, httptest.NewRequest("GET", "/free", nil))
+	if rec.Code != 200 || rec.Header().Get("RateLimit-Limit") != "" {
+		t.Fatalf("Expected routes without the middleware to be unlimited")
+	}
+}
+
+func TestRateLimitKeyByUser(t *testing.T) {
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	s.Get("/", func() string { return "ok" }).Use(RateLimit(RateLimitConfig{Limit: 1, Window: time.Minute, Key: KeyByUser}))
+	s.Get("/user/(.*)", func() string { return "ok" }).Use(func(ctx *Context, next func()) {
+		ctx.SetPrincipal(&Principal{Name: ctx.Params["user"]})
+		next()
+	}, RateLimit(RateLimitConfig{Limit: 1, Window: time.Minute, Key: KeyByUser}))
+
+	tests := []struct {
+		path   string
+		user   string
+		status int
+	}{
+		// unverified user names do not get buckets of their own
+		{"/", "alice", 200},
+		{"/", "bob", 429},
+		{"/user/x?user=alice", "", 200},
+		{"/user/x?user=alice", "", 429},
+		{"/user/x?user=bob", "", 200},
+	}
+	for i, test := range tests {
+		req := httptest.NewRequest("GET", test.path, nil)
+		if test.user != "" {
+			req.SetBasicAuth(test.user, "wrong")
+		}
+		rec := httptest.NewRecorder()
+		s.Process(rec, req)
+		if rec.Code != test.status {
+			t.Errorf("Test %d: expected %d, got %d", i, test.status, rec.Code)
+		}
+	}
+}
+
+func TestRateLimitSlidingWindow(t *testing.T) {
+	config := RateLimitConfig{Algorithm: SlidingWindow, Limit: 10, Window: time.Minute}
+	state := &RateLimitState{}
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 10; i++ {
+		if ok, _, _ := config.slidingWindow(state, start.Add(time.Duration(i)*time.Second)); !ok {
+			t.Fatalf("Request %d should be allowed", i)
+		}
+	}
+	if ok, _, reset := config.slidingWindow(state, start.Add(30*time.Second)); ok || reset != 30*time.Second {
+		t.Fatalf("Expected the 11th request to be rejected until the window ends, got %v, %v", ok, reset)
+	}
+	// a quarter into the next window, 7 of the previous 10 requests still count
+	next := start.Add(75 * time.Second)
+	for i := 0; i < 3; i++ {
+		if ok, _, _ := config.slidingWindow(state, next); !ok {
+			t.Fatalf("Request %d in the next window should be allowed", i)
+		}
+	}
+	if ok, _, _ := config.slidingWindow(state, next); ok {
+		t.Fatalf("Expected the weighted previous window to be counted")
+	}
+}
+
+func TestMemoryStoreEviction(t *testing.T) {
+	store := NewMemoryStore(10 * time.Millisecond)
+	store.Update("a", func(state *RateLimitState) {})
+	time.Sleep(20 * time.Millisecond)
+	store.Update("b", func(state *RateLimitState) {})
+	if store.Len() != 1 {
+		t.Fatalf("Expected idle keys to be evicted, got %d keys", store.Len())
+	}
+}
+
+func TestRateLimitInvalidConfig(t *testing.T) {
+	configs := []RateLimitConfig{
+		{Limit: 1},
+		{Window: time.Second},
+		{Limit: -1, Window: time.Second, Algorithm: SlidingWindow},
+	}
+	for _, config := range configs {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expected %+v to be rejected", config)
+				}
+			}()
+			RateLimit(config)
+		}()
+	}
+}
@@ -0,0 +1,248 @@
+package web
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// ErrResponseFinished is returned for writes to a response that has been
+// finished with Abort, Redirect or NotFound.
+var ErrResponseFinished = errors.New("Response has already been finished")
+
+// responseWriter wraps the http.ResponseWriter of a request to keep track
+// of what has been sent. It is safe for concurrent use, so that a handler
+// that timed out can not interfere with the timeout response.
+type responseWriter struct {
+	http.ResponseWriter
+	mu     sync.Mutex
+	status int
+	// implicitStatus is sent if the header is written by Write or Flush
+	implicitStatus int
+	size           int
+	written        bool
+	finished       bool
+	timedOut       bool
+	// header, if set, buffers the header until it is written
+	header http.Header
+	// deadline, if set, is the context of a handler timeout; the
+	// response no longer accepts writes once it is done
+	deadline context.Context
+}
+
+func (w *responseWriter) expired() bool {
+	return w.timedOut || (w.deadline != nil && w.deadline.Err() != nil)
+}
+
+func (w *responseWriter) Header() http.Header {
+	if w.header != nil {
+		return w.header
+	}
+	return w.ResponseWriter.Header()
+}
+
+// WriteHeader sends the response header. Only the first call has an effect.
+func (w *responseWriter) WriteHeader(status int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.writeHeader(status)
+}
+
+func (w *responseWriter) writeHeader(status int) {
+	if w.written || w.expired() {
+		return
+	}
+	w.commitHeader()
+	w.status = status
+	w.written = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// commitHeader copies a buffered header to the underlying writer.
+func (w *responseWriter) commitHeader() {
+	if w.header == nil {
+		return
+	}
+	header := w.ResponseWriter.Header()
+	for k := range header {
+		delete(header, k)
+	}
+	for k, v := range w.header {
+		header[k] = v
+	}
+	w.header = nil
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.expired() {
+		return 0, http.ErrHandlerTimeout
+	}
+	if w.finished {
+		return 0, ErrResponseFinished
+	}
+	w.writeHeader(w.implicit())
+	n, err := w.ResponseWriter.Write(b)
+	w.size += n
+	return n, err
+}
+
+func (w *responseWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.expired() {
+		return
+	}
+	w.writeHeader(w.implicit())
+	w.ResponseWriter.(http.Flusher).Flush()
+}
+
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.expired() {
+		return nil, nil, http.ErrHandlerTimeout
+	}
+	conn, rw, err := w.ResponseWriter.(http.Hijacker).Hijack()
+	if err == nil {
+		w.written = true
+	}
+	return conn, rw, err
+}
+
+func (w *responseWriter) Push(target string, opts *http.PushOptions) error {
+	return w.ResponseWriter.(http.Pusher).Push(target, opts)
+}
+
+func (w *responseWriter) implicit() int {
+	if w.implicitStatus != 0 {
+		return w.implicitStatus
+	}
+	return http.StatusOK
+}
+
+// setStatus sets the status that is sent if the header is written without
+// an explicit status.
+func (w *responseWriter) setStatus(status int) {
+	w.mu.Lock()
+	w.implicitStatus = status
+	w.mu.Unlock()
+}
+
+func (w *responseWriter) state() (status int, size int, written bool, finished bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.status, w.size, w.written, w.finished
+}
+
+func (w *responseWriter) finish() {
+	w.mu.Lock()
+	w.finished = true
+	w.mu.Unlock()
+}
+
+// setDeadline makes the writer reject writes once deadline is done. Header
+// changes are collected in a copy of the header until it is written.
+func (w *responseWriter) setDeadline(deadline context.Context) {
+	w.deadline = deadline
+	w.header = http.Header{}
+	for k, v := range w.ResponseWriter.Header() {
+		w.header[k] = append([]string(nil), v...)
+	}
+}
+
+// timeout ends the response with status, unless it was already started.
+// All further writes fail with http.ErrHandlerTimeout. An empty body only
+// stops the response, for clients that are gone.
+func (w *responseWriter) timeout(status int, body string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.timedOut = true
+	if w.written || body == "" {
+		return
+	}
+	w.ResponseWriter.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.status = status
+	w.written = true
+	w.ResponseWriter.WriteHeader(status)
+	w.size, _ = w.ResponseWriter.Write([]byte(body))
+}
+
+// wrap returns w with the optional interfaces of the underlying writer.
+func (w *responseWriter) wrap() http.ResponseWriter {
+	_, flusher := w.ResponseWriter.(http.Flusher)
+	_, hijacker := w.ResponseWriter.(http.Hijacker)
+	_, pusher := w.ResponseWriter.(http.Pusher)
+	switch {
+	case flusher && hijacker && pusher:
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+			http.Hijacker
+			http.Pusher
+		}{w, w, w, w}
+	case flusher && hijacker:
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+			http.Hijacker
+		}{w, w, w}
+	case flusher && pusher:
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+			http.Pusher
+		}{w, w, w}
+	case hijacker && pusher:
+		return struct {
+			http.ResponseWriter
+			http.Hijacker
+			http.Pusher
+		}{w, w, w}
+	case flusher:
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+		}{w, w}
+	case hijacker:
+		return struct {
+			http.ResponseWriter
+			http.Hijacker
+		}{w, w}
+	case pusher:
+		return struct {
+			http.ResponseWriter
+			http.Pusher
+		}{w, w}
+	}
+	return struct{ http.ResponseWriter }{w}
+}
+
+// Status returns the status code sent to the client, or 0 if the response
+// header has not been written yet.
+func (ctx *Context) Status() int {
+	status, _, _, _ := ctx.response.state()
+	return status
+}
+
+// Size returns the number of body bytes written to the response.
+func (ctx *Context) Size() int {
+	_, size, _, _ := ctx.response.state()
+	return size
+}
+
+// Written reports whether the response header has been sent, after which
+// the status and headers can no longer be changed.
+func (ctx *Context) Written() bool {
+	_, _, written, _ := ctx.response.state()
+	return written
+}
+
+func (ctx *Context) finished() bool {
+	_, _, _, finished := ctx.response.state()
+	return finished
+}
@@ -0,0 +1,106 @@
+package web
+
+import (
+	"bufio"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResponseState(t *testing.T) {
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	var status, size int
+	var written bool
+	s.Use(func(ctx *Context, next func()) {
+		next()
+		status, size, written = ctx.Status(), ctx.Size(), ctx.Written()
+	})
+	s.Get("/abort", func(ctx *Context) string {
+		ctx.Abort(403, "denied")
+		ctx.Abort(500, "again")
+		if _, err := ctx.Write([]byte("more")); err != ErrResponseFinished {
+			t.Errorf("Expected write after Abort to fail, got %v", err)
+		}
+		return "ignored"
+	})
+	s.Get("/redirect", func(ctx *Context) string {
+		ctx.Redirect(302, "http://example.com/")
+		return "ignored"
+	})
+	s.Get("/partial", func(ctx *Context) string {
+		ctx.WriteString("head ")
+		return "tail"
+	})
+	s.Get("/full", func() string { return "body" })
+
+	tests := []struct {
+		path          string
+		status        int
+		body          string
+		contentLength string
+	}{
+		{"/abort", 403, "denied", ""},
+		{"/redirect", 302, "Redirecting to: http://example.com/", ""},
+		{"/partial", 200, "head tail", ""},
+		{"/full", 200, "body", "4"},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		s.Process(rec, httptest.NewRequest("GET", test.path, nil))
+		if rec.Code != test.status || rec.Body.String() != test.body {
+			t.Errorf("%s: expected %d %q, got %d %q", test.path, test.status, test.body, rec.Code, rec.Body.String())
+		}
+		if got := rec.Header().Get("Content-Length"); got != test.contentLength {
+			t.Errorf("%s: expected Content-Length %q, got %q", test.path, test.contentLength, got)
+		}
+		if status != test.status || size != len(test.body) || !written {
+			t.Errorf("%s: expected state %d/%d, got %d/%d/%v", test.path, test.status, len(test.body), status, size, written)
+		}
+	}
+}
+
+type hijackRecorder struct {
+	*httptest.ResponseRecorder
+}
+
+func (h hijackRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	client, _ := net.Pipe()
+	return client, nil, nil
+}
+
+func TestResponseWriterInterfaces(t *testing.T) {
+	rec := httptest.NewRecorder()
+	ctx := &Context{}
+	ctx.Reset(httptest.NewRequest("GET", "/", nil), NewServer(), rec)
+	if _, ok := ctx.ResponseWriter.(http.Flusher); !ok {
+		t.Fatalf("Expected the writer to implement http.Flusher")
+	}
+	if _, ok := ctx.ResponseWriter.(http.Hijacker); ok {
+		t.Fatalf("Expected the writer not to implement http.Hijacker")
+	}
+	ctx.ResponseWriter.(http.Flusher).Flush()
+	if !ctx.Written() || ctx.Status() != 200 || !rec.Flushed {
+		t.Fatalf("Expected Flush to send the header")
+	}
+
+	ctx.Reset(httptest.NewRequest("GET", "/", nil), NewServer(), hijackRecorder{httptest.NewRecorder()})
+	hijacker, ok := ctx.ResponseWriter.(http.Hijacker)
+	if !ok {
+		t.Fatalf("Expected the writer to implement http.Hijacker")
+	}
+	if _, ok := ctx.ResponseWriter.(http.Pusher); ok {
+		t.Fatalf("Expected the writer not to implement http.Pusher")
+	}
+	conn, _, err := hijacker.Hijack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if !ctx.Written() {
+		t.Fatalf("Expected a hijacked connection to count as written")
+	}
+}
@@ -0,0 +1,160 @@
+package web
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"reflect"
+	"strconv"
+)
+
+// Response is a handler result carrying the status, header and body of the
+// response. A zero Status means 200 OK.
+type Response struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// A resultWriter writes the return values of a handler to the response.
+type resultWriter func(ctx *Context, ret []reflect.Value)
+
+var (
+	readerType   = reflect.TypeOf((*io.Reader)(nil)).Elem()
+	handlerType  = reflect.TypeOf((*http.Handler)(nil)).Elem()
+	responseType = reflect.TypeOf(Response{})
+)
+
+// newResultWriter returns the resultWriter for handlers of type t, which
+// may return nothing, a string or []byte, an int status and a string or
+// []byte, an io.Reader, an http.Handler or a Response. For compatibility,
+// a string or []byte may also be followed by other values, which are
+// ignored.
+func newResultWriter(t reflect.Type) (resultWriter, error) {
+	switch t.NumOut() {
+	case 0:
+		return nil, nil
+	case 1:
+		out := t.Out(0)
+		switch {
+		case isBody(out):
+			return func(ctx *Context, ret []reflect.Value) {
+				ctx.writeBody(0, bodyBytes(ret[0]))
+			}, nil
+		case out == responseType:
+			return func(ctx *Context, ret []reflect.Value) {
+				ctx.writeResponse(ret[0].Interface().(Response))
+			}, nil
+		case out == reflect.PtrTo(responseType):
+			return func(ctx *Context, ret []reflect.Value) {
+				if resp := ret[0].Interface().(*Response); resp != nil {
+					ctx.writeResponse(*resp)
+				}
+			}, nil
+		case out.Implements(handlerType):
+			return func(ctx *Context, ret []reflect.Value) {
+				if isNil(ret[0]) {
+					return
+				}
+				ret[0].Interface().(http.Handler).ServeHTTP(ctx.ResponseWriter, ctx.Request)
+			}, nil
+		case out.Implements(readerType):
+			return func(ctx *Context, ret []reflect.Value) {
+				if isNil(ret[0]) {
+					return
+				}
+				ctx.writeReader(ret[0].Interface().(io.Reader))
+			}, nil
+		}
+	case 2:
+		if t.Out(0).Kind() == reflect.Int && isBody(t.Out(1)) {
+			return func(ctx *Context, ret []reflect.Value) {
+				ctx.writeBody(int(ret[0].Int()), bodyBytes(ret[1]))
+			}, nil
+		}
+	}
+	if t.NumOut() > 1 && isBody(t.Out(0)) {
+		return func(ctx *Context, ret []reflect.Value) {
+			ctx.writeBody(0, bodyBytes(ret[0]))
+		}, nil
+	}
+	return nil, errors.New("Unsupported handler return type " + t.String())
+}
+
+func isBody(t reflect.Type) bool {
+	return t.Kind() == reflect.String || (t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8)
+}
+
+func bodyBytes(v reflect.Value) []byte {
+	if v.Kind() == reflect.String {
+		return []byte(v.String())
+	}
+	return v.Bytes()
+}
+
+func isNil(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Chan, reflect.Func, reflect.Interface, reflect.Map, reflect.Ptr, reflect.Slice:
+		return v.IsNil()
+	}
+	return false
+}
+
+// setDefaultContentType sets the Content-Type to HTML if the handler did not
+// set one.
+func (ctx *Context) setDefaultContentType() {
+	if ctx.ResponseWriter.Header().Get("Content-Type") == "" {
+		ctx.SetHeader("Content-Type", "text/html; charset=utf-8", true)
+	}
+}
+
+// writeBody writes content with status, or 200 OK if status is zero.
+func (ctx *Context) writeBody(status int, content []byte) {
+	ctx.setDefaultContentType()
+	// the header can only be set if the handler did not write yet
+	if !ctx.Written() {
+		ctx.SetHeader("Content-Length", strconv.Itoa(len(content)), true)
+	}
+	if status != 0 {
+		ctx.ResponseWriter.WriteHeader(status)
+	}
+	if _, err := ctx.ResponseWriter.Write(content); err != nil {
+		ctx.Server.Logger.Println("Error during write: ", err)
+	}
+}
+
+func (ctx *Context) writeResponse(resp Response) {
+	header := ctx.ResponseWriter.Header()
+	for k, v := range resp.Header {
+		header[k] = v
+	}
+	ctx.writeBody(resp.Status, resp.Body)
+}
+
+// writeReader streams r to the response and closes it if it is an
+// io.Closer. The Content-Length is set if the size of r is known.
+func (ctx *Context) writeReader(r io.Reader) {
+	if closer, ok := r.(io.Closer); ok {
+		defer closer.Close()
+	}
+	if !ctx.Written() {
+		size := int64(-1)
+		switch r := r.(type) {
+		case interface{ Len() int }:
+			size = int64(r.Len())
+		case *os.File:
+			// the handler may already have read part of the file
+			offset, err := r.Seek(0, io.SeekCurrent)
+			if info, statErr := r.Stat(); err == nil && statErr == nil && info.Mode().IsRegular() && offset <= info.Size() {
+				size = info.Size() - offset
+			}
+		}
+		if size >= 0 {
+			ctx.SetHeader("Content-Length", strconv.FormatInt(size, 10), true)
+		}
+	}
+	if _, err := io.Copy(ctx.ResponseWriter, r); err != nil {
+		ctx.Server.Logger.Println("Error during write: ", err)
+	}
+}
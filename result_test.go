@@ -0,0 +1,147 @@
+package web
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+type closeTracker struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeTracker) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestHandlerResults(t *testing.T) {
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+
+	tracker := &closeTracker{Reader: strings.NewReader("streamed")}
+	s.Get("/created", func() (int, string) { return 201, "created" })
+	s.Get("/teapot", func() (int, []byte) { return 418, []byte("teapot") })
+	s.Get("/reader", func() io.Reader { return bytes.NewBufferString("buffer") })
+	s.Get("/readcloser", func() io.ReadCloser { return tracker })
+	s.Get("/nilreader", func() io.Reader { return nil })
+	s.Get("/handler", func() http.Handler { return http.RedirectHandler("/other", 301) })
+	s.Get("/handlerfunc", func() http.HandlerFunc {
+		return func(w http.ResponseWriter, req *http.Request) { w.Write([]byte("func")) }
+	})
+	s.Get("/response", func() Response {
+		return Response{Status: 202, Header: http.Header{"Content-Type": {"application/json"}}, Body: []byte(`{}`)}
+	})
+	s.Get("/responseptr", func() *Response { return &Response{Body: []byte("ptr")} })
+	s.Get("/aborted", func(ctx *Context) (int, string) {
+		ctx.Abort(403, "denied")
+		return 200, "ignored"
+	})
+
+	tests := []struct {
+		path          string
+		status        int
+		body          string
+		contentType   string
+		contentLength string
+	}{
+		{"/created", 201, "created", "text/html; charset=utf-8", "7"},
+		{"/teapot", 418, "teapot", "text/html; charset=utf-8", "6"},
+		{"/reader", 200, "buffer", "", "6"},
+		{"/readcloser", 200, "streamed", "", ""},
+		{"/nilreader", 200, "", "", ""},
+		{"/handler", 301, "", "text/html; charset=utf-8", ""},
+		{"/handlerfunc", 200, "func", "", ""},
+		{"/response", 202, "{}", "application/json", "2"},
+		{"/responseptr", 200, "ptr", "text/html; charset=utf-8", "3"},
+		{"/aborted", 403, "denied", "text/html; charset=utf-8", ""},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		s.Process(rec, httptest.NewRequest("GET", test.path, nil))
+		if rec.Code != test.status || (test.body != "" && rec.Body.String() != test.body) {
+			t.Errorf("%s: expected %d %q, got %d %q", test.path, test.status, test.body, rec.Code, rec.Body.String())
+		}
+		if got := rec.Header().Get("Content-Type"); test.contentType != "" && got != test.contentType {
+			t.Errorf("%s: expected Content-Type %q, got %q", test.path, test.contentType, got)
+		}
+		if got := rec.Header().Get("Content-Length"); got != test.contentLength {
+			t.Errorf("%s: expected Content-Length %q, got %q", test.path, test.contentLength, got)
+		}
+	}
+	if !tracker.closed {
+		t.Fatalf("Expected the returned ReadCloser to be closed")
+	}
+}
+
+func TestInvalidHandlerResults(t *testing.T) {
+	s := NewServer()
+	handlers := []interface{}{
+		func() int { return 1 },
+		func() (int, string, error) { return 0, "", nil },
+		func() (io.Reader, error) { return nil, nil },
+		"not a function",
+	}
+	for _, handler := range handlers {
+		func() {
+			defer func() {
+				if err := recover(); err == nil || !strings.Contains(fmt.Sprint(err), "Error in handler") {
+					t.Errorf("Expected %T to be rejected, got %v", handler, err)
+				}
+			}()
+			s.Get("/invalid", handler)
+		}()
+	}
+	if len(s.routes) != 0 {
+		t.Fatalf("Expected invalid handlers not to be registered, got %d routes", len(s.routes))
+	}
+}
+
+func TestLegacyHandlerResults(t *testing.T) {
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	s.Get("/error", func() (string, error) { return "body", errors.New("ignored") })
+	s.Get("/int", func() (string, int) { return "body", 1 })
+
+	for _, path := range []string{"/error", "/int"} {
+		rec := httptest.NewRecorder()
+		s.Process(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Code != 200 || rec.Body.String() != "body" {
+			t.Errorf("%s: expected the first return value as body, got %d %q", path, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestFileResultOffset(t *testing.T) {
+	f, err := ioutil.TempFile("", "result")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("header\nbody")
+	f.Close()
+
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	s.Get("/", func() io.Reader {
+		f, _ := os.Open(f.Name())
+		// the handler consumes the first line itself
+		f.Seek(int64(len("header\n")), io.SeekStart)
+		return f
+	})
+
+	rec := httptest.NewRecorder()
+	s.Process(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Body.String() != "body" || rec.Header().Get("Content-Length") != "4" {
+		t.Fatalf("Expected the rest of the file with its length, got %q and %q", rec.Body.String(), rec.Header().Get("Content-Length"))
+	}
+}
@@ -8,9 +8,13 @@ import (
 	"crypto/rand"
 	"crypto/sha512"
 	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"io"
+	"strconv"
 	"strings"
+	"time"
 
 	"golang.org/x/crypto/pbkdf2"
 )
@@ -20,80 +24,293 @@ const (
 	keySize          = 32
 )
 
+// DefaultCookieMaxAge is the number of seconds a secure cookie is accepted
+// for when ServerConfig.CookieMaxAge is not set.
+const DefaultCookieMaxAge = 30 * 24 * 60 * 60
+
 var (
-	ErrMissingCookieSecret = errors.New("Secret Key for secure cookies has not been set. Assign one to web.Config.CookieSecret.")
-	ErrInvalidKey          = errors.New("The keys for secure cookies have not been initialized. Ensure that a Run* method is being called")
+	ErrMissingCookieSecret = errors.New("Secret Key for secure cookies has not been set. Assign one to web.Config.CookieSecret or web.Config.CookieKeys.")
+	ErrInvalidKey          = errors.New("The keys for secure cookies could not be loaded")
+	ErrCookieNotFound      = errors.New("Secure cookie not found")
+	ErrCookieExpired       = errors.New("Secure cookie has expired")
+	ErrCookieTampered      = errors.New("Secure cookie is malformed or its signature does not match")
 )
 
+// SetSecureCookie encrypts and signs val and sets it as the cookie name.
+// The cookie name and its expiry time are part of the signature, so the
+// value can neither be moved to another cookie nor used after it expired.
+// The server side lifetime is age seconds, limited to ServerConfig.CookieMaxAge.
+// The cookie gets the server's default cookie options.
 func (ctx *Context) SetSecureCookie(name string, val string, age int64) error {
-	server := ctx.Server
-	if len(server.Config.CookieSecret) == 0 {
-		return ErrMissingCookieSecret
-	}
-	if len(server.encKey) == 0 || len(server.signKey) == 0 {
-		return ErrInvalidKey
-	}
-	ciphertext, err := encrypt([]byte(val), server.encKey)
+	return ctx.SetSecureCookieWithOptions(name, val, ctx.Server.cookieOptions().withAge(age))
+}
+
+// SetSecureCookieWithOptions is like SetSecureCookie, but sets the cookie
+// with the given options. For session cookies (opts.MaxAge is zero) the
+// server side lifetime is ServerConfig.CookieMaxAge.
+func (ctx *Context) SetSecureCookieWithOptions(name string, val string, opts CookieOptions) error {
+	data, err := ctx.Server.encodeSecureCookie(name, val, int64(opts.MaxAge), time.Now())
 	if err != nil {
 		return err
 	}
-	sig := sign(ciphertext, server.signKey)
-	data := base64.StdEncoding.EncodeToString(ciphertext) + "|" + base64.StdEncoding.EncodeToString(sig)
-	ctx.SetCookie(NewCookie(name, data, age))
+	ctx.setCookieChunks(name, data, opts)
 	return nil
 }
 
+// GetSecureCookie returns the decrypted value of the cookie name. The
+// second return value is false if the cookie is absent, expired or invalid.
 func (ctx *Context) GetSecureCookie(name string) (string, bool) {
-	for _, cookie := range ctx.Request.Cookies() {
-		if cookie.Name != name {
-			continue
+	val, err := ctx.SecureCookie(name)
+	if err != nil {
+		return "", false
+	}
+	return val, true
+}
+
+// SecureCookie returns the decrypted value of the cookie name. The error is
+// ErrCookieNotFound if the request has no such cookie, ErrCookieExpired if
+// its lifetime has passed and ErrCookieTampered if it failed verification.
+func (ctx *Context) SecureCookie(name string) (string, error) {
+	data, err := ctx.readCookieChunks(name)
+	if err != nil {
+		return "", err
+	}
+	val, err := ctx.Server.decodeSecureCookie(name, data, time.Now())
+	if err == nil && isUnversionedCookie(data) {
+		// cookies of the original format never expire, so they are replaced
+		if err := ctx.SetSecureCookieWithOptions(name, val, ctx.Server.cookieOptions()); err != nil {
+			ctx.Server.Logger.Println("Error replacing unversioned secure cookie:", err)
 		}
-		parts := strings.SplitN(cookie.Value, "|", 2)
-		if len(parts) != 2 {
-			return "", false
+	}
+	return val, err
+}
+
+// cookieMaxAge returns the maximum lifetime of secure cookies in seconds.
+func (s *Server) cookieMaxAge() int64 {
+	if s.Config.CookieMaxAge > 0 {
+		return s.Config.CookieMaxAge
+	}
+	return DefaultCookieMaxAge
+}
+
+// Secure cookie values come in three formats. Version 2 is a single
+// URL-safe base64 string of
+//
+//	version (1 byte) | key id (4 bytes) | expiry (8 bytes) | nonce | AES-GCM ciphertext
+//
+// where the cookie name and the header fields are authenticated as additional
+// data. Version 1 cookies, version|key id|expiry|AES-CTR ciphertext|HMAC, and
+// the original unversioned cookies, AES-CTR ciphertext|HMAC of the
+// ciphertext, are still accepted so that existing sessions survive an
+// upgrade; the latter only until ServerConfig.UnversionedCookiesUntil.
+const (
+	cookieVersionCTR    = "1"
+	cookieVersionGCM    = 2
+	cookieKeyIDSize     = 4
+	cookieGCMHeaderSize = 1 + cookieKeyIDSize + 8
+)
+
+var cookieEncoding = base64.RawURLEncoding
+
+func (s *Server) encodeSecureCookie(name string, val string, age int64, now time.Time) (string, error) {
+	keys, err := s.loadCookieKeys()
+	if err != nil {
+		return "", err
+	}
+	key := keys[0]
+	maxAge := s.cookieMaxAge()
+	if age <= 0 || age > maxAge {
+		age = maxAge
+	}
+	aead, err := newGCM(key.encKey)
+	if err != nil {
+		return "", err
+	}
+
+	data := make([]byte, cookieGCMHeaderSize+aead.NonceSize(), cookieGCMHeaderSize+aead.NonceSize()+len(val)+aead.Overhead())
+	data[0] = cookieVersionGCM
+	copy(data[1:], key.id)
+	binary.BigEndian.PutUint64(data[1+cookieKeyIDSize:], uint64(now.Unix()+age))
+	nonce := data[cookieGCMHeaderSize:]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	header := data[:cookieGCMHeaderSize]
+	data = aead.Seal(data, nonce, []byte(val), cookieAdditionalData(name, header))
+	return cookieEncoding.EncodeToString(data), nil
+}
+
+func (s *Server) decodeSecureCookie(name string, value string, now time.Time) (string, error) {
+	keys, err := s.loadCookieKeys()
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(value, cookieVersionCTR+"|") {
+		return s.decodeCTRCookie(keys, name, value, now)
+	}
+	if isUnversionedCookie(value) {
+		return s.decodeUnversionedCookie(keys, value, now)
+	}
+
+	data, err := cookieEncoding.DecodeString(value)
+	if err != nil || len(data) < cookieGCMHeaderSize || data[0] != cookieVersionGCM {
+		return "", ErrCookieTampered
+	}
+	key, err := findCookieKey(keys, data[1:1+cookieKeyIDSize], now)
+	if err != nil {
+		return "", err
+	}
+	aead, err := newGCM(key.encKey)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < cookieGCMHeaderSize+aead.NonceSize() {
+		return "", ErrCookieTampered
+	}
+	header := data[:cookieGCMHeaderSize]
+	nonce := data[cookieGCMHeaderSize : cookieGCMHeaderSize+aead.NonceSize()]
+	plaintext, err := aead.Open(nil, nonce, data[len(header)+len(nonce):], cookieAdditionalData(name, header))
+	if err != nil {
+		return "", ErrCookieTampered
+	}
+	expires := int64(binary.BigEndian.Uint64(data[1+cookieKeyIDSize:]))
+	if err := s.checkCookieExpiry(expires, now); err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// decodeCTRCookie reads a version 1 cookie.
+func (s *Server) decodeCTRCookie(keys []*cookieKey, name string, value string, now time.Time) (string, error) {
+	parts := strings.Split(value, "|")
+	if len(parts) != 5 {
+		return "", ErrCookieTampered
+	}
+	id, err := hex.DecodeString(parts[1])
+	if err != nil {
+		return "", ErrCookieTampered
+	}
+	key, err := findCookieKey(keys, id, now)
+	if err != nil {
+		return "", err
+	}
+	expires, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return "", ErrCookieTampered
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return "", ErrCookieTampered
+	}
+	sig, err := base64.StdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return "", ErrCookieTampered
+	}
+	data := value[:len(value)-len(parts[4])-1]
+	expectedSig := sign(cookieAdditionalData(name, []byte(data)), key.signKey)
+	if !hmac.Equal(expectedSig, sig) {
+		return "", ErrCookieTampered
+	}
+	if err := s.checkCookieExpiry(expires, now); err != nil {
+		return "", err
+	}
+	plaintext, err := decrypt(ciphertext, key.encKey)
+	if err != nil {
+		return "", ErrCookieTampered
+	}
+	return string(plaintext), nil
+}
+
+// isUnversionedCookie reports whether value has the original format.
+func isUnversionedCookie(value string) bool {
+	return !strings.HasPrefix(value, cookieVersionCTR+"|") && strings.Count(value, "|") == 1
+}
+
+// decodeUnversionedCookie reads a cookie of the original format, which has
+// neither a key id nor an expiry, so every current key is tried. As it
+// could be replayed forever, it is only accepted until
+// ServerConfig.UnversionedCookiesUntil.
+func (s *Server) decodeUnversionedCookie(keys []*cookieKey, value string, now time.Time) (string, error) {
+	until := s.Config.UnversionedCookiesUntil
+	if until.IsZero() || now.After(until) {
+		return "", ErrCookieExpired
+	}
+	parts := strings.Split(value, "|")
+	ciphertext, err := base64.StdEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", ErrCookieTampered
+	}
+	sig, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", ErrCookieTampered
+	}
+	for i, key := range keys {
+		if i > 0 && !key.expires.IsZero() && now.After(key.expires) {
+			continue
 		}
-		ciphertext, err := base64.StdEncoding.DecodeString(parts[0])
-		if err != nil {
-			return "", false
+		if !hmac.Equal(sign(ciphertext, key.signKey), sig) {
+			continue
 		}
-		sig, err := base64.StdEncoding.DecodeString(parts[1])
+		plaintext, err := decrypt(ciphertext, key.encKey)
 		if err != nil {
-			return "", false
+			return "", ErrCookieTampered
 		}
-		expectedSig := sign([]byte(ciphertext), ctx.Server.signKey)
-		if !bytes.Equal(expectedSig, sig) {
-			return "", false
+		return string(plaintext), nil
+	}
+	return "", ErrCookieTampered
+}
+
+// checkCookieExpiry reports whether a cookie expiring at the unix time
+// expires is still valid. A cookie issued for longer than the current
+// maximum age is treated as expired as well, so lowering CookieMaxAge
+// takes effect at once.
+func (s *Server) checkCookieExpiry(expires int64, now time.Time) error {
+	remaining := expires - now.Unix()
+	if remaining <= 0 || remaining > s.cookieMaxAge() {
+		return ErrCookieExpired
+	}
+	return nil
+}
+
+// findCookieKey returns the key with the given id. Cookies of a retired key
+// are reported as expired once its grace window has ended.
+func findCookieKey(keys []*cookieKey, id []byte, now time.Time) (*cookieKey, error) {
+	for i, key := range keys {
+		if !bytes.Equal(key.id, id) {
+			continue
 		}
-		plaintext, err := decrypt(ciphertext, ctx.Server.encKey)
-		if err != nil {
-			return "", false
+		if i > 0 && !key.expires.IsZero() && now.After(key.expires) {
+			return nil, ErrCookieExpired
 		}
-		return string(plaintext), true
+		return key, nil
 	}
-	return "", false
+	return nil, ErrCookieTampered
+}
+
+// cookieAdditionalData returns the data authenticated along with a secure
+// cookie's value: its name and the format specific header.
+func cookieAdditionalData(name string, header []byte) []byte {
+	data := make([]byte, 0, len(name)+1+len(header))
+	data = append(data, name...)
+	data = append(data, '|')
+	return append(data, header...)
 }
 
 func genKey(password string, salt string) []byte {
 	return pbkdf2.Key([]byte(password), []byte(salt), pbkdf2Iterations, keySize, sha512.New)
 }
 
-func encrypt(plaintext []byte, key []byte) ([]byte, error) {
+func newGCM(key []byte) (cipher.AEAD, error) {
 	aesCipher, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, err
 	}
-	ciphertext := make([]byte, aes.BlockSize+len(plaintext))
-	iv := ciphertext[:aes.BlockSize]
-	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
-		return nil, err
-	}
-	stream := cipher.NewCTR(aesCipher, iv)
-	stream.XORKeyStream(ciphertext[aes.BlockSize:], plaintext)
-	return ciphertext, nil
+	return cipher.NewGCM(aesCipher)
 }
 
+// decrypt reverses the AES-CTR encryption of version 1 cookies.
 func decrypt(ciphertext []byte, key []byte) ([]byte, error) {
-	if len(ciphertext) <= aes.BlockSize {
+	if len(ciphertext) < aes.BlockSize {
 		return nil, errors.New("Invalid cipher text")
 	}
 	aesCipher, err := aes.NewCipher(key)
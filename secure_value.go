@@ -0,0 +1,181 @@
+package web
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// A CookieCodec selects how SetSecureValue serializes values.
+type CookieCodec int
+
+const (
+	// JSONCodec encodes values with encoding/json.
+	JSONCodec CookieCodec = iota
+	// GobCodec encodes values with encoding/gob. Types stored in interface
+	// values must be registered with gob.Register.
+	GobCodec
+)
+
+// maxCookieChunk is the largest cookie value written in a single cookie.
+// Browsers drop cookies of more than about 4096 bytes including the name
+// and attributes.
+const maxCookieChunk = 3800
+
+// minCompressSize is the smallest payload that is compressed when
+// ServerConfig.CookieCompression is set.
+const minCompressSize = 128
+
+// The first byte of a secure value records how it was encoded, so values
+// remain readable after the codec configuration changes.
+const (
+	valueCodecMask  = 0x0f
+	valueCompressed = 0x80
+)
+
+var ErrUnknownCookieCodec = errors.New("Secure value uses an unknown codec")
+
+// SetSecureValue serializes value with the configured ServerConfig.CookieCodec,
+// optionally compresses it and stores it in the secure cookie name. Values
+// too large for a single cookie are split across the cookies name, name.1,
+// name.2 and so on.
+func (ctx *Context) SetSecureValue(name string, value interface{}, age int64) error {
+	payload, err := encodeSecureValue(value, ctx.Server.Config.CookieCodec, ctx.Server.Config.CookieCompression)
+	if err != nil {
+		return err
+	}
+	return ctx.SetSecureCookie(name, string(payload), age)
+}
+
+// GetSecureValue reads the secure cookie name written by SetSecureValue and
+// decodes it into value, which must be a pointer. It returns the same errors
+// as SecureCookie.
+func (ctx *Context) GetSecureValue(name string, value interface{}) error {
+	payload, err := ctx.SecureCookie(name)
+	if err != nil {
+		return err
+	}
+	return decodeSecureValue([]byte(payload), value)
+}
+
+func encodeSecureValue(value interface{}, codec CookieCodec, compress bool) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(byte(codec))
+	var err error
+	switch codec {
+	case JSONCodec:
+		err = json.NewEncoder(&buf).Encode(value)
+	case GobCodec:
+		err = gob.NewEncoder(&buf).Encode(value)
+	default:
+		err = ErrUnknownCookieCodec
+	}
+	if err != nil {
+		return nil, err
+	}
+	payload := buf.Bytes()
+	if !compress || len(payload) < minCompressSize {
+		return payload, nil
+	}
+
+	var compressed bytes.Buffer
+	compressed.WriteByte(payload[0] | valueCompressed)
+	w, _ := flate.NewWriter(&compressed, flate.BestCompression)
+	w.Write(payload[1:])
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	if compressed.Len() >= len(payload) {
+		return payload, nil
+	}
+	return compressed.Bytes(), nil
+}
+
+func decodeSecureValue(payload []byte, value interface{}) error {
+	if len(payload) == 0 {
+		return ErrCookieTampered
+	}
+	flags, data := payload[0], payload[1:]
+	if flags&valueCompressed != 0 {
+		var err error
+		data, err = ioutil.ReadAll(flate.NewReader(bytes.NewReader(data)))
+		if err != nil {
+			return err
+		}
+	}
+	switch CookieCodec(flags & valueCodecMask) {
+	case JSONCodec:
+		return json.Unmarshal(data, value)
+	case GobCodec:
+		return gob.NewDecoder(bytes.NewReader(data)).Decode(value)
+	}
+	return ErrUnknownCookieCodec
+}
+
+// setCookieChunks sets the cookie name to value, splitting it into numbered
+// chunk cookies if necessary. The first cookie of a split value is prefixed
+// with the number of chunks. Chunks left over from a previous, larger value
+// are deleted.
+func (ctx *Context) setCookieChunks(name string, value string, opts CookieOptions) {
+	n := (len(value) + maxCookieChunk - 1) / maxCookieChunk
+	if n <= 1 {
+		ctx.SetCookie(opts.NewCookie(name, value))
+	} else {
+		ctx.SetCookie(opts.NewCookie(name, strconv.Itoa(n)+":"+value[:maxCookieChunk]))
+		for i := 1; i < n; i++ {
+			end := (i + 1) * maxCookieChunk
+			if end > len(value) {
+				end = len(value)
+			}
+			ctx.SetCookie(opts.NewCookie(cookieChunkName(name, i), value[i*maxCookieChunk:end]))
+		}
+	}
+
+	if n < 1 {
+		n = 1
+	}
+	opts.MaxAge = -1
+	for i := n; ; i++ {
+		if _, err := ctx.Request.Cookie(cookieChunkName(name, i)); err != nil {
+			break
+		}
+		ctx.SetCookie(opts.NewCookie(cookieChunkName(name, i), ""))
+	}
+}
+
+// readCookieChunks returns the value of the cookie name, joining the chunks
+// written by setCookieChunks.
+func (ctx *Context) readCookieChunks(name string) (string, error) {
+	cookie, err := ctx.Request.Cookie(name)
+	if err != nil {
+		return "", ErrCookieNotFound
+	}
+	pos := strings.IndexByte(cookie.Value, ':')
+	if pos < 0 {
+		return cookie.Value, nil
+	}
+	n, err := strconv.Atoi(cookie.Value[:pos])
+	if err != nil || n < 2 {
+		return "", ErrCookieTampered
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(cookie.Value[pos+1:])
+	for i := 1; i < n; i++ {
+		chunk, err := ctx.Request.Cookie(cookieChunkName(name, i))
+		if err != nil {
+			return "", ErrCookieTampered
+		}
+		buf.WriteString(chunk.Value)
+	}
+	return buf.String(), nil
+}
+
+func cookieChunkName(name string, i int) string {
+	return name + "." + strconv.Itoa(i)
+}
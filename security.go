@@ -0,0 +1,149 @@
+package web
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// SecurityHeadersConfig configures the SecurityHeaders middleware. Empty
+// fields omit their header.
+type SecurityHeadersConfig struct {
+	// HSTSMaxAge is the max-age of the Strict-Transport-Security header in
+	// seconds. The header is only sent on HTTPS requests.
+	HSTSMaxAge            int
+	HSTSIncludeSubdomains bool
+	HSTSPreload           bool
+	// ContentTypeNosniff sends "X-Content-Type-Options: nosniff".
+	ContentTypeNosniff bool
+	// FrameOptions is the X-Frame-Options header, "DENY" or "SAMEORIGIN".
+	FrameOptions      string
+	ReferrerPolicy    string
+	PermissionsPolicy string
+	// ContentSecurityPolicy is the Content-Security-Policy header. Every
+	// "{nonce}" in it is replaced by a random nonce generated for each
+	// request, which handlers get from Context.CSPNonce.
+	ContentSecurityPolicy     string
+	CrossOriginOpenerPolicy   string
+	CrossOriginEmbedderPolicy string
+	CrossOriginResourcePolicy string
+}
+
+// DefaultSecurityHeaders returns a strict configuration for applications
+// serving their own scripts and styles: framing is denied and inline
+// scripts and styles need the request's nonce.
+func DefaultSecurityHeaders() SecurityHeadersConfig {
+	return SecurityHeadersConfig{
+		HSTSMaxAge:                365 * 24 * 60 * 60,
+		HSTSIncludeSubdomains:     true,
+		ContentTypeNosniff:        true,
+		FrameOptions:              "DENY",
+		ReferrerPolicy:            "strict-origin-when-cross-origin",
+		PermissionsPolicy:         "camera=(), microphone=(), geolocation=()",
+		ContentSecurityPolicy:     "default-src 'self'; script-src 'self' 'nonce-{nonce}'; style-src 'self' 'nonce-{nonce}'; object-src 'none'; base-uri 'self'; frame-ancestors 'none'",
+		CrossOriginOpenerPolicy:   "same-origin",
+		CrossOriginResourcePolicy: "same-origin",
+	}
+}
+
+// SecurityHeaders returns middleware that adds the security headers of
+// config to responses. Routes and groups can replace the configuration with
+// their SecurityHeaders methods. Like all middleware it only runs for
+// requests that match a route, so the responses the server sends without
+// one, such as 404 pages, rejected hosts and preflight requests of
+// unmatched routes, do not get the headers. If no nonce can be generated
+// for the Content-Security-Policy, the request fails with 500.
+func SecurityHeaders(config SecurityHeadersConfig) Middleware {
+	return func(ctx *Context, next func()) {
+		c := &config
+		if override := ctx.route.securityHeadersConfig(); override != nil {
+			c = override
+		}
+		if err := c.writeHeaders(ctx); err != nil {
+			ctx.Server.Logger.Println("Error generating CSP nonce:", err)
+			ctx.Error(500, "Server Error")
+			return
+		}
+		next()
+	}
+}
+
+// SecurityHeaders replaces the configuration of the SecurityHeaders
+// middleware for route r.
+func (r *Route) SecurityHeaders(config SecurityHeadersConfig) *Route {
+	r.securityHeaders = &config
+	return r
+}
+
+// SecurityHeaders replaces the configuration of the SecurityHeaders
+// middleware for all routes of g.
+func (g *Group) SecurityHeaders(config SecurityHeadersConfig) {
+	g.securityHeaders = &config
+}
+
+// securityHeadersConfig returns the configuration of route r or of its
+// nearest group, or nil.
+func (r *Route) securityHeadersConfig() *SecurityHeadersConfig {
+	if r == nil {
+		return nil
+	}
+	if r.securityHeaders != nil {
+		return r.securityHeaders
+	}
+	for g := r.group; g != nil; g = g.parent {
+		if g.securityHeaders != nil {
+			return g.securityHeaders
+		}
+	}
+	return nil
+}
+
+func (config *SecurityHeadersConfig) writeHeaders(ctx *Context) error {
+	header := ctx.ResponseWriter.Header()
+	set := func(name string, value string) {
+		if value != "" {
+			header.Set(name, value)
+		}
+	}
+
+	if config.HSTSMaxAge > 0 && ctx.Scheme() == "https" {
+		hsts := "max-age=" + strconv.Itoa(config.HSTSMaxAge)
+		if config.HSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+		if config.HSTSPreload {
+			hsts += "; preload"
+		}
+		header.Set("Strict-Transport-Security", hsts)
+	}
+	if config.ContentTypeNosniff {
+		header.Set("X-Content-Type-Options", "nosniff")
+	}
+	set("X-Frame-Options", config.FrameOptions)
+	set("Referrer-Policy", config.ReferrerPolicy)
+	set("Permissions-Policy", config.PermissionsPolicy)
+	set("Cross-Origin-Opener-Policy", config.CrossOriginOpenerPolicy)
+	set("Cross-Origin-Embedder-Policy", config.CrossOriginEmbedderPolicy)
+	set("Cross-Origin-Resource-Policy", config.CrossOriginResourcePolicy)
+
+	csp := config.ContentSecurityPolicy
+	if strings.Contains(csp, "{nonce}") {
+		nonce := make([]byte, 16)
+		if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+			return err
+		}
+		ctx.cspNonce = base64.StdEncoding.EncodeToString(nonce)
+		csp = strings.Replace(csp, "{nonce}", ctx.cspNonce, -1)
+	}
+	set("Content-Security-Policy", csp)
+	return nil
+}
+
+// CSPNonce returns the nonce of the request's Content-Security-Policy, or
+// an empty string if the policy has none. Inline scripts are allowed with
+// <script nonce="{{.CSPNonce}}"> in templates executed with the Context.
+func (ctx *Context) CSPNonce() string {
+	return ctx.cspNonce
+}
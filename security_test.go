@@ -0,0 +1,97 @@
+package web
+
+import (
+	"crypto/rand"
+	"io/ioutil"
+	"log"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	s.Config = &ServerConfig{TrustedProxies: []string{"10.0.0.1"}}
+	s.Use(SecurityHeaders(DefaultSecurityHeaders()))
+	s.Get("/", func(ctx *Context) string { return ctx.CSPNonce() })
+
+	embed := DefaultSecurityHeaders()
+	embed.FrameOptions = ""
+	embed.ContentSecurityPolicy = "frame-ancestors https://partner.example.com"
+	s.Get("/widget", func(ctx *Context) string { return ctx.CSPNonce() }).SecurityHeaders(embed)
+
+	api := s.Group("/api")
+	api.SecurityHeaders(SecurityHeadersConfig{ContentTypeNosniff: true})
+	api.Get("/items", func() string { return "[]" })
+
+	rec := httptest.NewRecorder()
+	s.Process(rec, httptest.NewRequest("GET", "/", nil))
+	header := rec.Header()
+	nonce := rec.Body.String()
+	if len(nonce) < 22 {
+		t.Fatalf("Expected a nonce, got %q", nonce)
+	}
+	if csp := header.Get("Content-Security-Policy"); !strings.Contains(csp, "script-src 'self' 'nonce-"+nonce+"'") {
+		t.Fatalf("Expected nonce in policy, got %q", csp)
+	}
+	expected := map[string]string{
+		"X-Content-Type-Options":       "nosniff",
+		"X-Frame-Options":              "DENY",
+		"Referrer-Policy":              "strict-origin-when-cross-origin",
+		"Cross-Origin-Opener-Policy":   "same-origin",
+		"Cross-Origin-Embedder-Policy": "",
+		"Strict-Transport-Security":    "",
+	}
+	for k, v := range expected {
+		if got := header.Get(k); got != v {
+			t.Fatalf("Expected %s %q, got %q", k, v, got)
+		}
+	}
+
+	rec2 := httptest.NewRecorder()
+	s.Process(rec2, httptest.NewRequest("GET", "/", nil))
+	if rec2.Body.String() == nonce {
+		t.Fatalf("Expected a new nonce for every request")
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-Proto", "https")
+	rec = httptest.NewRecorder()
+	s.Process(rec, req)
+	if got := rec.Header().Get("Strict-Transport-Security"); got != "max-age=31536000; includeSubDomains" {
+		t.Fatalf("Expected HSTS on https requests, got %q", got)
+	}
+
+	rec = httptest.NewRecorder()
+	s.Process(rec, httptest.NewRequest("GET", "/widget", nil))
+	if got := rec.Header().Get("X-Frame-Options"); got != "" {
+		t.Fatalf("Expected route override without X-Frame-Options, got %q", got)
+	}
+	if got := rec.Header().Get("Content-Security-Policy"); got != embed.ContentSecurityPolicy || rec.Body.String() != "" {
+		t.Fatalf("Expected route policy without nonce, got %q and %q", got, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	s.Process(rec, httptest.NewRequest("GET", "/api/items", nil))
+	if rec.Header().Get("X-Content-Type-Options") != "nosniff" || rec.Header().Get("Content-Security-Policy") != "" {
+		t.Fatalf("Expected group override, got %v", rec.Header())
+	}
+}
+
+func TestSecurityHeadersNonceError(t *testing.T) {
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	s.Use(SecurityHeaders(DefaultSecurityHeaders()))
+	s.Get("/", func(ctx *Context) string { return "nonce " + ctx.CSPNonce() })
+
+	reader := rand.Reader
+	rand.Reader = strings.NewReader("")
+	defer func() { rand.Reader = reader }()
+	rec := httptest.NewRecorder()
+	s.Process(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != 500 || rec.Header().Get("Content-Security-Policy") != "" {
+		t.Fatalf("Expected the request to fail without a nonce, got %d %q", rec.Code, rec.Body.String())
+	}
+}
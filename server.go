@@ -2,16 +2,16 @@ package web
 
 import (
 	"bytes"
+	"crypto/tls"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"net/http/pprof"
 	"os"
 	"reflect"
 	"regexp"
-	"runtime"
 	"strconv"
-	"strings"
 	"sync"
 	"time"
 )
@@ -22,9 +22,59 @@ type ServerConfig struct {
 	Addr         string
 	Port         int
 	CookieSecret string
-	RecoverPanic bool
-	Profiler     bool
-	ColorOutput  bool
+	// CookieMaxAge is the number of seconds after which secure cookies are
+	// rejected. DefaultCookieMaxAge is used if it is zero.
+	CookieMaxAge int64
+	// CookieKeys supplies the keys for secure cookies. If it is nil,
+	// CookieSecret is used as the only key.
+	CookieKeys KeyProvider
+	// UnversionedCookiesUntil ends the migration of secure cookies issued
+	// in the original format, which have no expiry. Until then they are
+	// accepted and replaced with cookies of the current format when read.
+	// Afterwards, or if it is zero, they are rejected as expired.
+	UnversionedCookiesUntil time.Time
+	// CookieCodec and CookieCompression control how SetSecureValue
+	// serializes values.
+	CookieCodec       CookieCodec
+	CookieCompression bool
+	// CookieOptions are the default attributes of cookies set through the
+	// Context. If it is nil, DefaultCookieOptions is used.
+	CookieOptions *CookieOptions
+	// Development enables conveniences for running the server locally,
+	// such as cookies that are not restricted to HTTPS.
+	Development bool
+	// TrustedProxies lists the addresses or CIDR ranges of reverse proxies
+	// whose Forwarded and X-Forwarded-* headers are honoured by ClientIP,
+	// Scheme and Host. It is read when the server first needs it.
+	TrustedProxies []string
+	// ProxyProtocolPeers lists the addresses or CIDR ranges of the load
+	// balancers that send a PROXY protocol header. Run, RunTLS and Serve
+	// require the header on their connections and ignore it on others. It
+	// is separate from TrustedProxies, which only vouches for HTTP headers.
+	ProxyProtocolPeers []string
+	// AllowedHosts lists the host names the server answers to, protecting
+	// generated links against forged Host headers. An entry may contain a
+	// single '*' wildcard, such as "*.example.com". Requests for other hosts
+	// are rejected with 400. If it is empty, any host is allowed.
+	AllowedHosts []string
+	// HandlerTimeout limits the time a route may take to handle a request.
+	// When it expires, the request's context is canceled and the client
+	// gets 503 Service Unavailable. Zero means no limit.
+	HandlerTimeout time.Duration
+	// WebSocket configures the WebSocket routes. A route can replace it
+	// with Route.WebSocketConfig.
+	WebSocket *WebSocketConfig
+	// Hub configures the hub returned by Server.Hub.
+	Hub *HubConfig
+	// PoisonContexts is a debugging aid for handlers that keep using their
+	// Context after returning. Instead of being reused, every Context is
+	// poisoned when its request ends, so that later use panics: of its
+	// methods, its response and its Request. The Params map can not
+	// detect reads, which return "", only writes panic.
+	PoisonContexts bool
+	RecoverPanic   bool
+	Profiler       bool
+	ColorOutput    bool
 }
 
 type typeHandlerDelegate func(reflect.Type, []string, int, *Context) (reflect.Value, error)
@@ -32,12 +82,24 @@ type typeHandlerDelegate func(reflect.Type, []string, int, *Context) (reflect.Va
 // Server represents a web.go server.
 type Server struct {
 	Config       *ServerConfig
-	routes       []*route
+	routes       []*Route
+	middleware   []Middleware
+	cors         *CORSConfig
 	Logger       *log.Logger
 	Env          map[string]interface{}
 	TypeHandlers []typeHandlerDelegate
-	encKey       []byte
-	signKey      []byte
+	// PanicHandler, if set, replaces the default handling of panics.
+	PanicHandler  PanicHandler
+	groups        []*Group
+	errorHandlers map[int]*Route
+	cookieKeys    []*cookieKey
+	keysMu        sync.RWMutex
+	proxies       []*net.IPNet
+	proxiesOnce   sync.Once
+	peers         []*net.IPNet
+	peersOnce     sync.Once
+	hub           *Hub
+	hubOnce       sync.Once
 }
 
 func NewServer() *Server {
@@ -45,7 +107,7 @@ func NewServer() *Server {
 		Config:       Config,
 		Logger:       log.New(os.Stdout, "", log.Ldate|log.Ltime),
 		Env:          map[string]interface{}{},
-		TypeHandlers: []typeHandlerDelegate{getString, getInt, getContext},
+		TypeHandlers: []typeHandlerDelegate{getString, getInt, getContext, getPrincipal, getJWTClaims, getContextContext, getWebSocketConn},
 	}
 }
 
@@ -65,41 +127,48 @@ func (s *Server) initServer() {
 		s.Get("/debug/pprof/symbol", http.HandlerFunc(pprof.Symbol))
 	}
 
-	if len(s.Config.CookieSecret) > 0 {
+	if s.keyProvider() != nil {
 		s.Logger.Println("Generating cookie encryption keys")
-		s.encKey = genKey(s.Config.CookieSecret, "encryption key salt")
-		s.signKey = genKey(s.Config.CookieSecret, "signature key salt")
+		if err := s.ReloadCookieKeys(); err != nil {
+			s.Logger.Println("Error loading cookie keys:", err)
+		}
 	}
 }
 
-type route struct {
-	path         string
-	pathRegex    *regexp.Regexp
-	method       string
-	handler      reflect.Value
-	httpHandler  http.Handler
-	runner       func() reflect.Value
-	argsBuilders []func([]string, *Context) reflect.Value
+// A Route is a handler registered for a method and path pattern. The
+// methods registering handlers return it, so route specific settings can
+// be chained onto the registration.
+type Route struct {
+	path            string
+	pathRegex       *regexp.Regexp
+	method          string
+	handler         reflect.Value
+	httpHandler     http.Handler
+	argsBuilders    []func([]string, *Context) reflect.Value
+	writeResult     resultWriter
+	middleware      []Middleware
+	group           *Group
+	csrfExempt      bool
+	requirements    []requirement
+	securityHeaders *SecurityHeadersConfig
+	host            *hostPattern
+	timeout         time.Duration
+	websocket       bool
+	websocketConfig *WebSocketConfig
 }
 
 var dummyArgs = []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9"}
 
-func (route *route) call() reflect.Value {
-	if route.httpHandler != nil {
-		route.httpHandler.ServeHTTP(nil, nil)
-		return reflect.Value{}
-	}
-
-	return reflect.Value{}
-}
-
-func newRouteFromHandler(pathRegex string, cr *regexp.Regexp, method string, handler http.Handler) *route {
+func newRouteFromHandler(pathRegex string, cr *regexp.Regexp, method string, handler http.Handler) *Route {
 	route := newRoute(pathRegex, cr, method)
 	route.httpHandler = handler
 	return route
 }
 
-func (s *Server) newRouteFromValue(pathRegex string, cr *regexp.Regexp, method string, handler reflect.Value) *route {
+func (s *Server) newRouteFromValue(pathRegex string, cr *regexp.Regexp, method string, handler reflect.Value) (*Route, error) {
+	if handler.Kind() != reflect.Func {
+		return nil, fmt.Errorf("Handler of type %s is not a function", handler.Type())
+	}
 	route := newRoute(pathRegex, cr, method)
 	route.handler = handler
 	route.argsBuilders = []func([]string, *Context) reflect.Value{}
@@ -107,6 +176,12 @@ func (s *Server) newRouteFromValue(pathRegex string, cr *regexp.Regexp, method s
 	var args []reflect.Value
 	functionType := handler.Type()
 
+	var err error
+	route.writeResult, err = newResultWriter(functionType)
+	if err != nil {
+		return nil, err
+	}
+
 	numIn := functionType.NumIn()
 
 	iVal := 1
@@ -138,33 +213,47 @@ func (s *Server) newRouteFromValue(pathRegex string, cr *regexp.Regexp, method s
 		iVal++
 	}
 
-	return route
+	return route, nil
 }
 
-func newRoute(pathRegex string, cr *regexp.Regexp, method string) *route {
-	return &route{
+func newRoute(pathRegex string, cr *regexp.Regexp, method string) *Route {
+	return &Route{
 		path:      pathRegex,
 		pathRegex: cr,
 		method:    method,
 	}
 }
 
-func (s *Server) addRoute(pathRegex string, method string, handler interface{}) {
+// addRoute registers handler for method and pathRegex. It panics if the
+// regex or the handler is invalid.
+func (s *Server) addRoute(pathRegex string, method string, handler interface{}) *Route {
 	cr, err := regexp.Compile("^" + pathRegex + "$")
 	if err != nil {
-		s.Logger.Printf("Error in route regex %q\n", pathRegex)
-		return
+		panic(fmt.Sprintf("Error in route regex %q: %v", pathRegex, err))
 	}
 
+	route, err := s.newHandlerRoute(pathRegex, cr, method, handler)
+	if err != nil {
+		panic(fmt.Sprintf("Error in handler for route %q: %v", pathRegex, err))
+	}
+	s.routes = append(s.routes, route)
+	return route
+}
+
+// newHandlerRoute returns an unregistered route for handler, which is an
+// http.Handler, a function or a reflect.Value of a function.
+func (s *Server) newHandlerRoute(pathRegex string, cr *regexp.Regexp, method string, handler interface{}) (*Route, error) {
 	switch handler.(type) {
+	case webSocketHandler:
+		return s.newWebSocketRoute(pathRegex, cr, method, handler.(webSocketHandler))
 	case http.Handler:
-		s.routes = append(s.routes, newRouteFromHandler(pathRegex, cr, method, handler.(http.Handler)))
+		return newRouteFromHandler(pathRegex, cr, method, handler.(http.Handler)), nil
 	case reflect.Value:
 		fv := handler.(reflect.Value)
-		s.routes = append(s.routes, s.newRouteFromValue(pathRegex, cr, method, fv))
+		return s.newRouteFromValue(pathRegex, cr, method, fv)
 	default:
 		fv := reflect.ValueOf(handler)
-		s.routes = append(s.routes, s.newRouteFromValue(pathRegex, cr, method, fv))
+		return s.newRouteFromValue(pathRegex, cr, method, fv)
 	}
 }
 
@@ -175,96 +264,121 @@ func (s *Server) ServeHTTP(c http.ResponseWriter, req *http.Request) {
 
 // Process invokes the routing system for server s
 func (s *Server) Process(c http.ResponseWriter, req *http.Request) {
-	route := s.routeHandler(req, c)
-	if route != nil {
-		route.httpHandler.ServeHTTP(c, req)
+	s.routeHandler(req, c)
+}
+
+// Run starts the server, listening for HTTP requests on addr.
+func (s *Server) Run(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// RunTLS starts the server, listening for HTTPS requests on addr.
+func (s *Server) RunTLS(addr string, config *tls.Config) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	// the PROXY header precedes the TLS handshake
+	return s.serve(tls.NewListener(s.proxyListener(l), config))
+}
+
+// Serve accepts HTTP connections on l.
+func (s *Server) Serve(l net.Listener) error {
+	return s.serve(s.proxyListener(l))
+}
+
+func (s *Server) serve(l net.Listener) error {
+	s.initServer()
+	s.Logger.Printf("web.go serving %s\n", l.Addr())
+	return (&http.Server{Handler: s}).Serve(l)
+}
+
+// proxyListener wraps l in a ProxyListener if ServerConfig.ProxyProtocolPeers
+// is set. Listeners that are already wrapped are returned unchanged.
+func (s *Server) proxyListener(l net.Listener) net.Listener {
+	if _, ok := l.(*ProxyListener); ok || s.Config == nil || len(s.Config.ProxyProtocolPeers) == 0 {
+		return l
+	}
+	return &ProxyListener{
+		Listener: l,
+		Trusted: func(addr net.Addr) bool {
+			return containsIP(s.proxyProtocolPeers(), hostOnly(addr.String()))
+		},
 	}
 }
 
 // Head adds a handler for the 'HEAD' http method for server s.
-func (s *Server) Head(route string, handler interface{}) {
-	s.addRoute(route, "GET", handler)
+func (s *Server) Head(route string, handler interface{}) *Route {
+	return s.addRoute(route, "GET", handler)
 }
 
 // Get adds a handler for the 'GET' http method for server s.
-func (s *Server) Get(route string, handler interface{}) {
-	s.addRoute(route, "GET", handler)
+func (s *Server) Get(route string, handler interface{}) *Route {
+	return s.addRoute(route, "GET", handler)
 }
 
 // Post adds a handler for the 'POST' http method for server s.
-func (s *Server) Post(route string, handler interface{}) {
-	s.addRoute(route, "POST", handler)
+func (s *Server) Post(route string, handler interface{}) *Route {
+	return s.addRoute(route, "POST", handler)
 }
 
 // Put adds a handler for the 'PUT' http method for server s.
-func (s *Server) Put(route string, handler interface{}) {
-	s.addRoute(route, "PUT", handler)
+func (s *Server) Put(route string, handler interface{}) *Route {
+	return s.addRoute(route, "PUT", handler)
 }
 
 // Delete adds a handler for the 'DELETE' http method for server s.
-func (s *Server) Delete(route string, handler interface{}) {
-	s.addRoute(route, "DELETE", handler)
+func (s *Server) Delete(route string, handler interface{}) *Route {
+	return s.addRoute(route, "DELETE", handler)
 }
 
 // Match adds a handler for an arbitrary http method for server s.
-func (s *Server) Match(method string, route string, handler interface{}) {
-	s.addRoute(route, method, handler)
+func (s *Server) Match(method string, route string, handler interface{}) *Route {
+	return s.addRoute(route, method, handler)
 }
 
 // Add a custom http.Handler
-func (s *Server) Handle(route string, method string, httpHandler http.Handler) {
-	s.addRoute(route, method, httpHandler)
+func (s *Server) Handle(route string, method string, httpHandler http.Handler) *Route {
+	return s.addRoute(route, method, httpHandler)
 }
 
-// safelyCall invokes `function` in recover block
-func (s *Server) safelyCall(function reflect.Value, args []reflect.Value) (resp []reflect.Value, e interface{}) {
-	defer func() {
-		if err := recover(); err != nil {
-			if !s.Config.RecoverPanic {
-				// go back to panic
-				panic(err)
-			} else {
-				e = err
-				resp = nil
-				s.Logger.Println("Handler crashed with error", err)
-				for i := 1; ; i += 1 {
-					_, file, line, ok := runtime.Caller(i)
-					if !ok {
-						break
-					}
-					s.Logger.Println(file, line)
-				}
-			}
-		}
-	}()
-	return function.Call(args), nil
+// requestLog is what logRequest reports about a request.
+type requestLog struct {
+	clientIP string
+	method   string
+	path     string
+	params   map[string]string
 }
 
-func (s *Server) logRequest(ctx *Context, sTime time.Time) {
-	//log the request
-	req := ctx.Request
-	requestPath := req.URL.Path
+// requestLog returns the log entry of the request. If detached is set,
+// the params are copied, so that the entry stays valid while a timed out
+// handler still runs.
+func (ctx *Context) requestLog(detached bool) requestLog {
+	params := ctx.Params
+	if detached {
+		params = make(map[string]string, len(ctx.Params))
+		for k, v := range ctx.Params {
+			params[k] = v
+		}
+	}
+	return requestLog{ctx.ClientIP(), ctx.Request.Method, ctx.Request.URL.Path, params}
+}
 
+func (s *Server) logRequest(entry requestLog, sTime time.Time) {
 	duration := time.Now().Sub(sTime)
-	var client string
-
-	// We suppose RemoteAddr is of the form Ip:Port as specified in the Request
-	// documentation at http://golang.org/pkg/net/http/#Request
-	pos := strings.LastIndex(req.RemoteAddr, ":")
-	if pos > 0 {
-		client = req.RemoteAddr[0:pos]
-	} else {
-		client = req.RemoteAddr
-	}
 
 	var logEntry bytes.Buffer
-	logEntry.WriteString(client)
-	logEntry.WriteString(" - " + s.ttyGreen(req.Method+" "+requestPath))
+	logEntry.WriteString(entry.clientIP)
+	logEntry.WriteString(" - " + s.ttyGreen(entry.method+" "+entry.path))
 	logEntry.WriteString(" - " + duration.String())
-	if len(ctx.Params) > 0 {
-		logEntry.WriteString(" - " + s.ttyWhite(fmt.Sprintf("Params: %v\n", ctx.Params)))
+	if len(entry.params) > 0 {
+		logEntry.WriteString(" - " + s.ttyWhite(fmt.Sprintf("Params: %v\n", entry.params)))
 	}
-	ctx.Server.Logger.Print(logEntry.String())
+	s.Logger.Print(logEntry.String())
 }
 
 func (s *Server) ttyGreen(msg string) string {
@@ -292,13 +406,22 @@ var contextPool = sync.Pool{
 // the main route handler in web.go
 // Tries to handle the given request.
 // Finds the route matching the request, and execute the callback associated
-// with it.  In case of custom http handlers, this function returns an "unused"
-// route. The caller is then responsible for calling the httpHandler associated
-// with the returned route.
-func (s *Server) routeHandler(req *http.Request, w http.ResponseWriter) (unused *route) {
+// with it, after the middleware of the route.
+func (s *Server) routeHandler(req *http.Request, w http.ResponseWriter) {
 	ctx := contextPool.Get().(*Context)
 	ctx.Reset(req, s, w)
-	defer contextPool.Put(ctx)
+	// a handler that timed out may still use its Context
+	reuse := true
+	defer func() {
+		if !reuse {
+			return
+		}
+		if s.Config.PoisonContexts {
+			ctx.poison()
+			return
+		}
+		contextPool.Put(ctx)
+	}()
 
 	//ignore errors from ParseForm because it's usually harmless.
 	req.ParseForm()
@@ -309,7 +432,21 @@ func (s *Server) routeHandler(req *http.Request, w http.ResponseWriter) (unused
 	}
 
 	tm := time.Now().UTC()
-	defer s.logRequest(ctx, tm)
+	// a handler that timed out keeps its Context, so the entry is
+	// taken before it starts
+	var timedOutLog *requestLog
+	defer func() {
+		if timedOutLog != nil {
+			s.logRequest(*timedOutLog, tm)
+		} else {
+			s.logRequest(ctx.requestLog(false), tm)
+		}
+	}()
+
+	if !s.allowedHost(ctx) {
+		ctx.Error(400, "Invalid host")
+		return
+	}
 
 	requestPath := req.URL.Path
 	for i := 0; i < len(s.routes); i++ {
@@ -324,53 +461,73 @@ func (s *Server) routeHandler(req *http.Request, w http.ResponseWriter) (unused
 		if match == nil || len(match[0]) != len(requestPath) {
 			continue
 		}
-
-		// We can not handle custom http handlers here, give back to the caller.
-		if route.httpHandler != nil {
-			unused = route
-			return
+		hostMatch, ok := route.matchHost(ctx)
+		if !ok {
+			continue
 		}
-
-		args := make([]reflect.Value, len(route.argsBuilders))
-		for i, argBuilder := range route.argsBuilders {
-			arg := argBuilder(match, ctx)
-			args[i] = arg
+		if len(hostMatch) > 0 {
+			// the labels captured from the host precede the path's values
+			match = append(append([]string{match[0]}, hostMatch...), match[1:]...)
 		}
 
-		ret, err := s.safelyCall(route.handler, args)
-
-		// set the default content-type
-		if ctx.ResponseWriter.Header().Get("Content-Type") == "" {
-			ctx.SetHeader("Content-Type", "text/html; charset=utf-8", true)
+		ctx.route = route
+		if cors := s.corsConfig(route); cors != nil {
+			cors.writeHeaders(ctx)
 		}
-
-		if err != nil {
-			//there was an error or panic while calling the handler
-			ctx.Abort(500, "Server Error")
+		handle := func() {
+			defer ctx.runFinishers()
+			s.safely(ctx, func() {
+				s.runMiddleware(ctx, route, func() {
+					if !ctx.authorize(route) {
+						return
+					}
+					if route.httpHandler != nil {
+						route.httpHandler.ServeHTTP(ctx.ResponseWriter, ctx.Request)
+						return
+					}
+					if route.websocket {
+						s.serveWebSocket(ctx, route, match)
+						return
+					}
+					s.callHandler(ctx, route, match)
+				})
+			})
 		}
-		if len(ret) == 0 {
-			return
+		if timeout := s.handlerTimeout(route); timeout > 0 {
+			entry := ctx.requestLog(true)
+			if reuse = s.runWithTimeout(ctx, timeout, handle); !reuse {
+				timedOutLog = &entry
+			}
+		} else {
+			handle()
 		}
+		return
+	}
 
-		sval := ret[0]
+	if s.handlePreflight(ctx) {
+		return
+	}
+	ctx.Error(404, "Page not found")
+	return
+}
 
-		var content []byte
+// callHandler invokes the reflective handler of route and writes its
+// return value to the response.
+func (s *Server) callHandler(ctx *Context, route *Route, match []string) {
+	args := make([]reflect.Value, len(route.argsBuilders))
+	for i, argBuilder := range route.argsBuilders {
+		arg := argBuilder(match, ctx)
+		args[i] = arg
+	}
 
-		if sval.Kind() == reflect.String {
-			content = []byte(sval.String())
-		} else if sval.Kind() == reflect.Slice && sval.Type().Elem().Kind() == reflect.Uint8 {
-			content = sval.Interface().([]byte)
-		}
-		ctx.SetHeader("Content-Length", strconv.Itoa(len(content)), true)
-		_, err = ctx.ResponseWriter.Write(content)
-		if err != nil {
-			ctx.Server.Logger.Println("Error during write: ", err)
-		}
+	ret := route.handler.Call(args)
+	if route.writeResult == nil {
+		ctx.setDefaultContentType()
 		return
 	}
-
-	ctx.Abort(404, "Page not found")
-	return
+	if !ctx.finished() {
+		route.writeResult(ctx, ret)
+	}
 }
 
 var NoValueNeeded = fmt.Errorf("No value needed")
@@ -0,0 +1,193 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultHeartbeat is the interval of the comments an EventStream sends to
+// keep idle connections open.
+const DefaultHeartbeat = 15 * time.Second
+
+var (
+	ErrStreamingUnsupported = errors.New("Response does not support streaming")
+	ErrStreamClosed         = errors.New("Event stream is closed")
+	ErrInvalidEventField    = errors.New("Event ID and name can not contain line breaks")
+)
+
+// An Event is a message of a Server-Sent Events stream. Empty fields are
+// omitted.
+type Event struct {
+	// ID and Event must be single lines.
+	ID    string
+	Event string
+	// Data may span several lines, separated by "\n", "\r\n" or "\r".
+	Data string
+	// Retry tells the client how long to wait before reconnecting.
+	Retry time.Duration
+}
+
+// An EventStream sends Server-Sent Events to the client. Its methods may be
+// called from several goroutines. It is closed when the handler returns.
+type EventStream struct {
+	ctx         *Context
+	flusher     http.Flusher
+	lastEventID string
+
+	mu        sync.Mutex
+	closed    bool
+	heartbeat chan time.Duration
+	stop      chan struct{}
+	stopped   chan struct{}
+}
+
+// SSE starts a Server-Sent Events response and returns the stream for
+// sending events. It fails with ErrStreamingUnsupported if the response
+// can not be flushed. Long-lived streams should not have a handler
+// timeout; see Route.Timeout.
+func (ctx *Context) SSE() (*EventStream, error) {
+	flusher, ok := ctx.ResponseWriter.(http.Flusher)
+	if !ok {
+		return nil, ErrStreamingUnsupported
+	}
+	header := ctx.ResponseWriter.Header()
+	header.Set("Content-Type", "text/event-stream")
+	// no-transform keeps proxies from compressing and buffering events
+	header.Set("Cache-Control", "no-cache, no-transform")
+	header.Set("X-Accel-Buffering", "no")
+	header.Del("Content-Length")
+	ctx.ResponseWriter.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	lastEventID := ctx.Request.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		// used by EventSource polyfills that can not set headers
+		lastEventID = ctx.Params["lastEventId"]
+	}
+	es := &EventStream{
+		ctx:         ctx,
+		flusher:     flusher,
+		lastEventID: lastEventID,
+		heartbeat:   make(chan time.Duration),
+		stop:        make(chan struct{}),
+		stopped:     make(chan struct{}),
+	}
+	go es.keepAlive(DefaultHeartbeat)
+	ctx.onFinish(es.Close)
+	return es, nil
+}
+
+// LastEventID returns the ID of the last event the client received before
+// reconnecting, so that the handler can resume the stream after it.
+func (es *EventStream) LastEventID() string {
+	return es.lastEventID
+}
+
+// Done returns a channel that is closed when the client disconnects.
+func (es *EventStream) Done() <-chan struct{} {
+	return es.ctx.Done()
+}
+
+// Send sends event and flushes it to the client. It fails with
+// ErrInvalidEventField if the ID or the name contains a line break.
+func (es *EventStream) Send(event Event) error {
+	if strings.ContainsAny(event.ID, "\r\n") || strings.ContainsAny(event.Event, "\r\n") {
+		return ErrInvalidEventField
+	}
+	var b strings.Builder
+	if event.ID != "" {
+		b.WriteString("id: " + event.ID + "\n")
+	}
+	if event.Event != "" {
+		b.WriteString("event: " + event.Event + "\n")
+	}
+	if event.Retry > 0 {
+		b.WriteString("retry: " + strconv.FormatInt(int64(event.Retry/time.Millisecond), 10) + "\n")
+	}
+	// every line break of the data must start a new data field, or the
+	// data could inject other fields
+	for _, line := range strings.Split(lineBreaks.Replace(event.Data), "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+	return es.write(b.String())
+}
+
+// Comment sends a comment line, which clients ignore.
+func (es *EventStream) Comment(text string) error {
+	return es.write(": " + singleLine(text) + "\n\n")
+}
+
+// SetHeartbeat changes the interval of the keep-alive comments. Zero
+// disables them.
+func (es *EventStream) SetHeartbeat(interval time.Duration) {
+	select {
+	case es.heartbeat <- interval:
+	case <-es.stopped:
+	}
+}
+
+// Close ends the stream. Events can no longer be sent afterwards.
+func (es *EventStream) Close() {
+	es.mu.Lock()
+	if !es.closed {
+		es.closed = true
+		close(es.stop)
+	}
+	es.mu.Unlock()
+	<-es.stopped
+}
+
+func (es *EventStream) write(s string) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if es.closed {
+		return ErrStreamClosed
+	}
+	if err := es.ctx.Err(); err != nil {
+		return err
+	}
+	if _, err := es.ctx.ResponseWriter.Write([]byte(s)); err != nil {
+		return err
+	}
+	es.flusher.Flush()
+	return nil
+}
+
+// keepAlive sends heartbeat comments until the stream is closed or the
+// client disconnects.
+func (es *EventStream) keepAlive(interval time.Duration) {
+	defer close(es.stopped)
+	done := es.ctx.Done()
+	for {
+		var tick <-chan time.Time
+		var timer *time.Timer
+		if interval > 0 {
+			timer = time.NewTimer(interval)
+			tick = timer.C
+		}
+		select {
+		case <-tick:
+			es.Comment("heartbeat")
+		case interval = <-es.heartbeat:
+		case <-es.stop:
+			return
+		case <-done:
+			return
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// lineBreaks normalizes the line breaks of the event stream format to "\n".
+var lineBreaks = strings.NewReplacer("\r\n", "\n", "\r", "\n")
+
+func singleLine(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
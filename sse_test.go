@@ -0,0 +1,120 @@
+package web
+
+import (
+	"bufio"
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventStream(t *testing.T) {
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+
+	var stream *EventStream
+	s.Get("/events", func(ctx *Context) {
+		es, err := ctx.SSE()
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream = es
+		es.Send(Event{ID: "2", Event: "update", Data: "line one\nline two", Retry: 3 * time.Second})
+		es.Send(Event{Data: "after " + es.LastEventID()})
+		es.Send(Event{Data: "x\revent: admin\rdata: injected"})
+		if err := es.Send(Event{ID: "3\rretry: 1", Data: "y"}); err != ErrInvalidEventField {
+			t.Errorf("Expected an ID with a line break to be rejected, got %v", err)
+		}
+		if err := es.Send(Event{Event: "a\nb"}); err != ErrInvalidEventField {
+			t.Errorf("Expected a name with a line break to be rejected, got %v", err)
+		}
+		es.Comment("bye\nnow")
+	})
+
+	req := httptest.NewRequest("GET", "/events", nil)
+	req.Header.Set("Last-Event-ID", "1")
+	rec := httptest.NewRecorder()
+	s.Process(rec, req)
+
+	expected := "id: 2\nevent: update\nretry: 3000\ndata: line one\ndata: line two\n\n" +
+		"data: after 1\n\n" +
+		"data: x\ndata: event: admin\ndata: data: injected\n\n" +
+		": byenow\n\n"
+	if rec.Body.String() != expected {
+		t.Fatalf("Expected body %q, got %q", expected, rec.Body.String())
+	}
+	if got := rec.Header().Get("Content-Type"); got != "text/event-stream" {
+		t.Fatalf("Expected Content-Type text/event-stream, got %q", got)
+	}
+	if got := rec.Header().Get("Cache-Control"); got != "no-cache, no-transform" {
+		t.Fatalf("Expected Cache-Control no-cache, no-transform, got %q", got)
+	}
+	if err := stream.Send(Event{Data: "late"}); err != ErrStreamClosed {
+		t.Fatalf("Expected ErrStreamClosed after the handler returned, got %v", err)
+	}
+}
+
+func TestEventStreamHeartbeat(t *testing.T) {
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	s.Get("/events", func(ctx *Context) {
+		es, _ := ctx.SSE()
+		es.SetHeartbeat(time.Millisecond)
+		time.Sleep(20 * time.Millisecond)
+		es.SetHeartbeat(0)
+	})
+
+	rec := httptest.NewRecorder()
+	s.Process(rec, httptest.NewRequest("GET", "/events", nil))
+	if !strings.Contains(rec.Body.String(), ": heartbeat\n\n") {
+		t.Fatalf("Expected heartbeat comments, got %q", rec.Body.String())
+	}
+}
+
+func TestEventStreamDisconnect(t *testing.T) {
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	disconnected := make(chan error, 1)
+	s.Get("/events", func(ctx *Context) {
+		es, _ := ctx.SSE()
+		es.Send(Event{Data: "hello"})
+		<-es.Done()
+		disconnected <- es.Send(Event{Data: "gone"})
+	})
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	reqCtx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequest("GET", server.URL+"/events", nil)
+	resp, err := http.DefaultClient.Do(req.WithContext(reqCtx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	line, _ := bufio.NewReader(resp.Body).ReadString('\n')
+	if line != "data: hello\n" {
+		t.Fatalf("Expected the first event, got %q", line)
+	}
+	cancel()
+	resp.Body.Close()
+
+	select {
+	case err := <-disconnected:
+		if err == nil {
+			t.Fatalf("Expected Send to fail after the client disconnected")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected the stream to end when the client disconnected")
+	}
+}
+
+func TestEventStreamUnsupported(t *testing.T) {
+	ctx := &Context{Request: httptest.NewRequest("GET", "/", nil)}
+	ctx.ResponseWriter = struct{ http.ResponseWriter }{httptest.NewRecorder()}
+	if _, err := ctx.SSE(); err != ErrStreamingUnsupported {
+		t.Fatalf("Expected ErrStreamingUnsupported, got %v", err)
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"net/http"
 	"reflect"
 	"strings"
+
+	"golang.org/x/net/websocket"
 )
 
 // A Context object is created for every incoming HTTP request, and is
@@ -18,12 +20,34 @@ type Context struct {
 	Params  map[string]string
 	Server  *Server
 	http.ResponseWriter
+	response   responseWriter
+	route      *Route
+	csrf       *csrfState
+	principal  *Principal
+	jwtPayload []byte
+	cspNonce   string
+	values     map[string]interface{}
+	finishers  []func()
+	websocket  *websocket.Conn
+	// errorStatus is the status of the error handler that is running
+	errorStatus int
+	poisoned    bool
 }
 
 func (ctx *Context) Reset(req *http.Request, s *Server, w http.ResponseWriter) {
 	ctx.Request = req
 	ctx.Server = s
-	ctx.ResponseWriter = w
+	ctx.response = responseWriter{ResponseWriter: w}
+	ctx.ResponseWriter = ctx.response.wrap()
+	ctx.route = nil
+	ctx.csrf = nil
+	ctx.principal = nil
+	ctx.jwtPayload = nil
+	ctx.cspNonce = ""
+	ctx.values = nil
+	ctx.finishers = nil
+	ctx.websocket = nil
+	ctx.errorStatus = 0
 	for k := range ctx.Params {
 		delete(ctx.Params, k)
 	}
@@ -37,18 +61,35 @@ func (ctx *Context) WriteString(content string) {
 // Abort is a helper method that sends an HTTP header and an optional
 // body. It is useful for returning 4xx or 5xx errors.
 // Once it has been called, any return value from the handler will
-// not be written to the response.
+// not be written to the response, and further writes fail with
+// ErrResponseFinished.
 func (ctx *Context) Abort(status int, body string) {
+	if ctx.finished() {
+		return
+	}
 	ctx.SetHeader("Content-Type", "text/html; charset=utf-8", true)
 	ctx.ResponseWriter.WriteHeader(status)
 	ctx.ResponseWriter.Write([]byte(body))
+	ctx.response.finish()
 }
 
-// Redirect is a helper method for 3xx redirects.
+// Redirect is a helper method for 3xx redirects. If the server validates
+// hosts with ServerConfig.AllowedHosts, a url starting with "/" is made
+// absolute with the Scheme and Host of the request.
 func (ctx *Context) Redirect(status int, url_ string) {
+	// make paths absolute, using the scheme and host the client used; an
+	// unvalidated host could point the redirect anywhere
+	validHost := ctx.Server != nil && ctx.Server.Config != nil && len(ctx.Server.Config.AllowedHosts) > 0
+	if validHost && strings.HasPrefix(url_, "/") && !strings.HasPrefix(url_, "//") {
+		url_ = ctx.Scheme() + "://" + ctx.Host() + url_
+	}
+	if ctx.finished() {
+		return
+	}
 	ctx.ResponseWriter.Header().Set("Location", url_)
 	ctx.ResponseWriter.WriteHeader(status)
 	ctx.ResponseWriter.Write([]byte("Redirecting to: " + url_))
+	ctx.response.finish()
 }
 
 // BadRequest writes a 400 HTTP response
@@ -73,8 +114,12 @@ func (ctx *Context) Forbidden() {
 
 // NotFound writes a 404 HTTP response
 func (ctx *Context) NotFound(message string) {
+	if ctx.finished() {
+		return
+	}
 	ctx.ResponseWriter.WriteHeader(404)
 	ctx.ResponseWriter.Write([]byte(message))
+	ctx.response.finish()
 }
 
 // ContentType sets the Content-Type header for an HTTP response.
@@ -2,7 +2,11 @@ package web
 
 import (
 	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,11 +14,15 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"os"
+	"reflect"
 	"runtime"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
 var testServer *Server
@@ -291,12 +299,14 @@ var tests = []Test{
 	{"GET", "/getparam?a=abcd", nil, "", 200, "abcd"},
 	{"GET", "/getparam?b=abcd", nil, "", 200, ""},
 	{"GET", "/fullparams?a=1&a=2&a=3", nil, "", 200, "1,2,3"},
-	{"GET", "/panic", nil, "", 500, "Server Error"},
+	{"GET", "/panic", nil, "", 500, "<!DOCTYPE html>\n<html>\n<head><title>Server Error</title></head>\n<body>\n<h1>Server Error</h1>\n<p>Error ID: ..."},
 	{"GET", "/json?a=1&b=2", nil, "", 200, `{"a":"1","b":"2"}`},
 	{"GET", "/jsonbytes?a=1&b=2", nil, "", 200, `{"a":"1","b":"2"}`},
 	{"POST", "/parsejson", map[string][]string{"Content-Type": {"application/json"}}, `{"a":"hello", "b":"world"}`, 200, "hello world"},
 	//{"GET", "/testenv", "", 200, "hello world"},
 	{"GET", "/authorization", map[string][]string{"Authorization": {BuildBasicAuthCredentials("foo", "bar")}}, "", 200, "foobar"},
+	{"GET", "/authorization", map[string][]string{"Authorization": {BuildBasicAuthCredentials("foo", "b:a:r")}}, "", 200, "foob:a:r"},
+	{"GET", "/authorization", map[string][]string{"Authorization": {"Basic"}}, "", 200, "fail"},
 	{"GET", "/authorization", nil, "", 200, "fail"},
 }
 
@@ -333,6 +343,16 @@ func buildTestRequest(method string, path string, body string, headers map[strin
 	return &req
 }
 
+// matchBody compares a response body with an expected body. An expected
+// body ending in "..." only has to be a prefix, for bodies such as error
+// pages that contain random IDs.
+func matchBody(body, expected string) bool {
+	if prefix := strings.TrimSuffix(expected, "..."); prefix != expected {
+		return strings.HasPrefix(body, prefix)
+	}
+	return body == expected
+}
+
 func TestRouting(t *testing.T) {
 	for _, test := range tests {
 		resp := getTestResponse(test.method, test.path, test.body, test.headers, nil)
@@ -340,7 +360,7 @@ func TestRouting(t *testing.T) {
 		if resp.statusCode != test.expectedStatus {
 			t.Fatalf("%v(%v) expected status %d got %d", test.method, test.path, test.expectedStatus, resp.statusCode)
 		}
-		if resp.body != test.expectedBody {
+		if !matchBody(resp.body, test.expectedBody) {
 			t.Fatalf("%v(%v) expected %q got %q", test.method, test.path, test.expectedBody, resp.body)
 		}
 		if cl, ok := resp.headers["Content-Length"]; ok {
@@ -473,6 +493,283 @@ func TestEmptySecureCookie(t *testing.T) {
 	}
 }
 
+func TestSecureCookieErrors(t *testing.T) {
+	s := NewServer()
+	s.Config = &ServerConfig{CookieSecret: "7C19QRmwf3mHZ9CPAaPQ0hsWeufKd"}
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	s.initServer()
+
+	now := time.Now()
+	val, err := s.encodeSecureCookie("a", "1", 60, now)
+	if err != nil {
+		t.Fatalf("Failed to encode secure cookie: %v", err)
+	}
+	if v, err := s.decodeSecureCookie("a", val, now); err != nil || v != "1" {
+		t.Fatalf("Expected secure cookie value %q, got %q (%v)", "1", v, err)
+	}
+	if _, err := s.decodeSecureCookie("b", val, now); err != ErrCookieTampered {
+		t.Fatalf("Expected a renamed cookie to be rejected as tampered, got %v", err)
+	}
+	if _, err := s.decodeSecureCookie("a", val, now.Add(61*time.Second)); err != ErrCookieExpired {
+		t.Fatalf("Expected ErrCookieExpired, got %v", err)
+	}
+
+	s.Config.CookieMaxAge = 30
+	if _, err := s.decodeSecureCookie("a", val, now); err != ErrCookieExpired {
+		t.Fatalf("Expected a lowered CookieMaxAge to expire the cookie, got %v", err)
+	}
+
+	req := buildTestRequest("GET", "/", "", nil, nil)
+	ctx := &Context{Request: req, Server: s, Params: map[string]string{}}
+	if _, err := ctx.SecureCookie("a"); err != ErrCookieNotFound {
+		t.Fatalf("Expected ErrCookieNotFound, got %v", err)
+	}
+}
+
+func TestSecureCookieKeyRotation(t *testing.T) {
+	s := NewServer()
+	s.Config = &ServerConfig{CookieKeys: StaticKeyProvider{{Secret: "old secret"}}}
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+
+	now := time.Now()
+	val, err := s.encodeSecureCookie("a", "1", 60, now)
+	if err != nil {
+		t.Fatalf("Failed to encode secure cookie: %v", err)
+	}
+
+	s.Config.CookieKeys = StaticKeyProvider{{Secret: "new secret"}, {Secret: "old secret", Expires: now.Add(time.Hour)}}
+	if err := s.ReloadCookieKeys(); err != nil {
+		t.Fatalf("Failed to reload cookie keys: %v", err)
+	}
+	if v, err := s.decodeSecureCookie("a", val, now); err != nil || v != "1" {
+		t.Fatalf("Expected a cookie of a retired key to be accepted, got %q (%v)", v, err)
+	}
+	if _, err := s.decodeSecureCookie("a", val, now.Add(2*time.Hour)); err != ErrCookieExpired {
+		t.Fatalf("Expected a cookie of an expired key to be rejected, got %v", err)
+	}
+
+	newVal, _ := s.encodeSecureCookie("a", "1", 60, now)
+	oldData, _ := cookieEncoding.DecodeString(val)
+	newData, _ := cookieEncoding.DecodeString(newVal)
+	if bytes.Equal(oldData[1:5], newData[1:5]) {
+		t.Fatalf("Expected new cookies to use the newest key")
+	}
+}
+
+func TestEnvKeyProvider(t *testing.T) {
+	const name = "WEB_TEST_COOKIE_KEYS"
+	defer os.Unsetenv(name)
+	encode := base64.StdEncoding.EncodeToString
+	os.Setenv(name, encode([]byte("new, secret"))+", "+encode([]byte("old secret"))+" 2030-01-01T00:00:00Z")
+	keys, err := EnvKeyProvider(name).CookieKeys()
+	if err != nil {
+		t.Fatalf("Failed to read cookie keys: %v", err)
+	}
+	expires, _ := time.Parse(time.RFC3339, "2030-01-01T00:00:00Z")
+	expected := []CookieKey{{Secret: "new, secret"}, {Secret: "old secret", Expires: expires}}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Fatalf("Expected keys %v, got %v", expected, keys)
+	}
+
+	os.Setenv(name, "not base64!")
+	if _, err := EnvKeyProvider(name).CookieKeys(); err == nil {
+		t.Fatalf("Expected a secret that is not base64 encoded to be rejected")
+	}
+}
+
+// encodeCTRCookie builds a version 1 secure cookie, as issued before the
+// switch to AES-GCM.
+func encodeCTRCookie(key *cookieKey, name string, val string, expires time.Time) string {
+	aesCipher, _ := aes.NewCipher(key.encKey)
+	ciphertext := make([]byte, aes.BlockSize+len(val))
+	iv := ciphertext[:aes.BlockSize]
+	rand.Read(iv)
+	cipher.NewCTR(aesCipher, iv).XORKeyStream(ciphertext[aes.BlockSize:], []byte(val))
+	data := "1|" + hex.EncodeToString(key.id) + "|" + strconv.FormatInt(expires.Unix(), 10) + "|" + base64.StdEncoding.EncodeToString(ciphertext)
+	return data + "|" + base64.StdEncoding.EncodeToString(sign(cookieAdditionalData(name, []byte(data)), key.signKey))
+}
+
+// encodeUnversionedCookie builds a secure cookie of the original format,
+// as issued by the first releases.
+func encodeUnversionedCookie(secret string, val string) string {
+	aesCipher, _ := aes.NewCipher(genKey(secret, "encryption key salt"))
+	ciphertext := make([]byte, aes.BlockSize+len(val))
+	iv := ciphertext[:aes.BlockSize]
+	rand.Read(iv)
+	cipher.NewCTR(aesCipher, iv).XORKeyStream(ciphertext[aes.BlockSize:], []byte(val))
+	sig := sign(ciphertext, genKey(secret, "signature key salt"))
+	return base64.StdEncoding.EncodeToString(ciphertext) + "|" + base64.StdEncoding.EncodeToString(sig)
+}
+
+func TestSecureCookieFormats(t *testing.T) {
+	s := NewServer()
+	s.Config = &ServerConfig{CookieSecret: "7C19QRmwf3mHZ9CPAaPQ0hsWeufKd"}
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	keys, _ := s.loadCookieKeys()
+
+	now := time.Now()
+	val, _ := s.encodeSecureCookie("a", "hello world", 60, now)
+	if strings.ContainsAny(val, "+/=|") {
+		t.Fatalf("Expected a URL-safe cookie value, got %q", val)
+	}
+
+	legacy := encodeCTRCookie(keys[0], "a", "hello world", now.Add(time.Minute))
+	if v, err := s.decodeSecureCookie("a", legacy, now); err != nil || v != "hello world" {
+		t.Fatalf("Expected a version 1 cookie to be accepted, got %q (%v)", v, err)
+	}
+	if _, err := s.decodeSecureCookie("b", legacy, now); err != ErrCookieTampered {
+		t.Fatalf("Expected a renamed version 1 cookie to be rejected, got %v", err)
+	}
+
+	original := encodeUnversionedCookie("7C19QRmwf3mHZ9CPAaPQ0hsWeufKd", "hello world")
+	if _, err := s.decodeSecureCookie("a", original, now); err != ErrCookieExpired {
+		t.Fatalf("Expected unversioned cookies to be rejected by default, got %v", err)
+	}
+	s.Config.UnversionedCookiesUntil = now.Add(time.Hour)
+	if v, err := s.decodeSecureCookie("a", original, now); err != nil || v != "hello world" {
+		t.Fatalf("Expected an unversioned cookie to be accepted, got %q (%v)", v, err)
+	}
+	if _, err := s.decodeSecureCookie("a", original, now.Add(2*time.Hour)); err != ErrCookieExpired {
+		t.Fatalf("Expected an unversioned cookie to be rejected after the migration, got %v", err)
+	}
+	if _, err := s.decodeSecureCookie("a", encodeUnversionedCookie("other secret", "hello world"), now); err != ErrCookieTampered {
+		t.Fatalf("Expected an unversioned cookie of another secret to be rejected, got %v", err)
+	}
+
+	data, _ := cookieEncoding.DecodeString(val)
+	data[len(data)-1] ^= 1
+	if _, err := s.decodeSecureCookie("a", cookieEncoding.EncodeToString(data), now); err != ErrCookieTampered {
+		t.Fatalf("Expected a modified cookie to be rejected, got %v", err)
+	}
+}
+
+func TestUnversionedCookieUpgrade(t *testing.T) {
+	s := NewServer()
+	s.Config = &ServerConfig{CookieSecret: "7C19QRmwf3mHZ9CPAaPQ0hsWeufKd", UnversionedCookiesUntil: time.Now().Add(time.Hour)}
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	s.Get("/", func(ctx *Context) string {
+		val, _ := ctx.GetSecureCookie("a")
+		return val
+	})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(&http.Cookie{Name: "a", Value: encodeUnversionedCookie("7C19QRmwf3mHZ9CPAaPQ0hsWeufKd", "hello world")})
+	rec := httptest.NewRecorder()
+	s.Process(rec, req)
+	cookies := rec.Result().Cookies()
+	if rec.Body.String() != "hello world" || len(cookies) != 1 || isUnversionedCookie(cookies[0].Value) {
+		t.Fatalf("Expected the cookie to be replaced, got %q and %v", rec.Body.String(), cookies)
+	}
+	if v, err := s.decodeSecureCookie("a", cookies[0].Value, time.Now()); err != nil || v != "hello world" {
+		t.Fatalf("Expected the replaced cookie to be valid, got %q (%v)", v, err)
+	}
+}
+
+func TestReadCookieKeys(t *testing.T) {
+	keys, err := readCookieKeys(strings.NewReader("# keys\nnew secret\n\nold secret 2026-01-02T15:04:05Z\n"))
+	if err != nil {
+		t.Fatalf("Failed to read keys: %v", err)
+	}
+	if len(keys) != 2 || keys[0].Secret != "new secret" || !keys[0].Expires.IsZero() {
+		t.Fatalf("Unexpected keys %#v", keys)
+	}
+	if keys[1].Secret != "old secret" || keys[1].Expires.Year() != 2026 {
+		t.Fatalf("Unexpected retired key %#v", keys[1])
+	}
+}
+
+func TestSecureValue(t *testing.T) {
+	s := NewServer()
+	s.Config = &ServerConfig{CookieSecret: "7C19QRmwf3mHZ9CPAaPQ0hsWeufKd"}
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+
+	type session struct {
+		User  string
+		Items []string
+	}
+	var items []string
+	for i := 0; i < 1000; i++ {
+		items = append(items, fmt.Sprintf("item %d", i))
+	}
+	want := session{User: "foo", Items: items}
+
+	for _, codec := range []CookieCodec{JSONCodec, GobCodec} {
+		for _, compress := range []bool{false, true} {
+			s.Config.CookieCodec = codec
+			s.Config.CookieCompression = compress
+
+			rec := httptest.NewRecorder()
+			ctx := &Context{Request: buildTestRequest("GET", "/", "", nil, nil), Server: s, ResponseWriter: rec}
+			if err := ctx.SetSecureValue("session", want, 60); err != nil {
+				t.Fatalf("SetSecureValue failed: %v", err)
+			}
+			cookies := rec.Result().Cookies()
+			if !compress && len(cookies) < 2 {
+				t.Fatalf("Expected a large value to be split into chunks, got %d cookies", len(cookies))
+			}
+			for _, cookie := range cookies {
+				if len(cookie.Value) > maxCookieChunk+10 {
+					t.Fatalf("Cookie %s is too large: %d bytes", cookie.Name, len(cookie.Value))
+				}
+			}
+
+			ctx.Request = buildTestRequest("GET", "/", "", nil, cookies)
+			var got session
+			if err := ctx.GetSecureValue("session", &got); err != nil {
+				t.Fatalf("GetSecureValue failed: %v", err)
+			}
+			if got.User != want.User || len(got.Items) != len(want.Items) || got.Items[999] != "item 999" {
+				t.Fatalf("Secure value does not match (codec %d, compress %v)", codec, compress)
+			}
+
+			if len(cookies) > 1 {
+				ctx.Request = buildTestRequest("GET", "/", "", nil, cookies[:len(cookies)-1])
+				if err := ctx.GetSecureValue("session", &got); err != ErrCookieTampered {
+					t.Fatalf("Expected a missing chunk to be detected, got %v", err)
+				}
+			}
+		}
+	}
+}
+
+func TestCookieOptions(t *testing.T) {
+	s := NewServer()
+	s.Config = &ServerConfig{CookieSecret: "7C19QRmwf3mHZ9CPAaPQ0hsWeufKd"}
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	cookies := []*http.Cookie{{Name: "a", Value: "1"}, {Name: "big.1", Value: "x"}}
+
+	rec := httptest.NewRecorder()
+	ctx := &Context{Request: buildTestRequest("GET", "/", "", nil, cookies), Server: s, ResponseWriter: rec}
+	if val, ok := ctx.GetCookie("a"); !ok || val != "1" {
+		t.Fatalf("GetCookie expected %q, got %q", "1", val)
+	}
+	if _, ok := ctx.GetCookie("b"); ok {
+		t.Fatalf("GetCookie found a cookie that was not sent")
+	}
+	ctx.SetSecureCookie("secure", "1", 0)
+	ctx.DeleteCookie("big")
+
+	result := rec.Result().Cookies()
+	if len(result) != 3 {
+		t.Fatalf("Expected 3 cookies, got %d", len(result))
+	}
+	secure := result[0]
+	if !secure.Secure || !secure.HttpOnly || secure.Path != "/" || secure.SameSite != http.SameSiteLaxMode {
+		t.Fatalf("Secure cookie does not use the default options: %s", secure)
+	}
+	if secure.MaxAge != permanentCookieAge {
+		t.Fatalf("Expected a permanent cookie, got Max-Age %d", secure.MaxAge)
+	}
+	if result[1].Name != "big" || result[1].MaxAge >= 0 || result[2].Name != "big.1" || result[2].MaxAge >= 0 {
+		t.Fatalf("Expected the cookie and its chunks to be deleted, got %s and %s", result[1], result[2])
+	}
+
+	s.Config.Development = true
+	if ctx.NewCookie("a", "1", 60).Secure {
+		t.Fatalf("Cookies should not be Secure by default in development mode")
+	}
+}
+
 func TestOptions(t *testing.T) {
 	resp := getTestResponse("OPTIONS", "/options", "", nil, nil)
 	if resp.headers["Access-Control-Allow-Methods"][0] != "POST, GET, OPTIONS" {
@@ -552,6 +849,42 @@ func TestCustomHandlerContentType(t *testing.T) {
 	}
 }
 
+// Custom HTTP handlers run inside the pipeline: after the middleware,
+// before the request is logged and with panics recovered.
+func TestCustomHandlerPipeline(t *testing.T) {
+	s := NewServer()
+	s.Config = &ServerConfig{RecoverPanic: true}
+	var logOutput bytes.Buffer
+	s.SetLogger(log.New(&logOutput, "", 0))
+	s.Use(func(ctx *Context, next func()) {
+		ctx.SetHeader("X-Middleware", "yes", true)
+		next()
+	})
+	s.Handle("/handler", "GET", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		s.Logger.Print("handler ran")
+		w.Write([]byte("custom"))
+	}))
+	s.Handle("/panic", "GET", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		panic("broken handler")
+	}))
+
+	rec := httptest.NewRecorder()
+	s.Process(rec, httptest.NewRequest("GET", "/handler", nil))
+	if rec.Body.String() != "custom" || rec.Header().Get("X-Middleware") != "yes" {
+		t.Fatalf("Expected custom handler after middleware, got %q %v", rec.Body.String(), rec.Header())
+	}
+	lines := strings.Split(strings.TrimSpace(logOutput.String()), "\n")
+	if len(lines) != 2 || lines[0] != "handler ran" || !strings.Contains(lines[1], "GET /handler") {
+		t.Fatalf("Expected the request to be logged after the handler ran, got %q", lines)
+	}
+
+	rec = httptest.NewRecorder()
+	s.Process(rec, httptest.NewRequest("GET", "/panic", nil))
+	if rec.Code != 500 {
+		t.Fatalf("Expected panic to be recovered with 500, got %d", rec.Code)
+	}
+}
+
 func BuildBasicAuthCredentials(user string, pass string) string {
 	s := user + ":" + pass
 	return "Basic " + base64.StdEncoding.EncodeToString([]byte(s))
@@ -0,0 +1,272 @@
+package web
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// DefaultPingInterval is the interval of the pings sent to keep
+	// WebSocket connections alive.
+	DefaultPingInterval = 30 * time.Second
+	// DefaultMaxMessageSize is the size limit of received WebSocket
+	// messages.
+	DefaultMaxMessageSize = 1 << 20
+)
+
+// WebSocketConfig configures WebSocket routes.
+type WebSocketConfig struct {
+	// AllowedOrigins lists the origins besides the request's own that may
+	// connect, such as "https://example.com". A "*" in a pattern matches
+	// any text. Clients that do not send an Origin header are allowed.
+	AllowedOrigins []string
+	// Subprotocols lists the supported subprotocols in order of
+	// preference. The first one offered by the client is selected.
+	Subprotocols []string
+	// PingInterval is the interval of the pings that keep the connection
+	// alive. If nothing, not even a pong, is read from the connection for
+	// a whole interval after a ping, the peer is considered dead and the
+	// connection is closed. Pongs are only processed while the handler
+	// reads from the connection, so handlers must keep reading. Zero means
+	// DefaultPingInterval, a negative value disables the pings.
+	PingInterval time.Duration
+	// MaxMessageSize limits the size of the messages received with
+	// websocket.Message or websocket.JSON. Zero means
+	// DefaultMaxMessageSize.
+	MaxMessageSize int
+}
+
+// webSocketHandler marks a handler registered with WebSocket.
+type webSocketHandler struct {
+	handler interface{}
+}
+
+var webSocketConnType = reflect.TypeOf((*websocket.Conn)(nil))
+
+// WebSocket adds a WebSocket endpoint for GET requests to route. The
+// handler is called once the connection is upgraded and takes the same
+// arguments as other handlers, including the *websocket.Conn of the
+// connection. It must not return values. Middleware and authorization run
+// before the upgrade, so they can still reject the request. WebSocket
+// routes have no handler timeout.
+func (s *Server) WebSocket(route string, handler interface{}) *Route {
+	return s.addRoute(route, "GET", webSocketHandler{handler})
+}
+
+// WebSocket adds a WebSocket endpoint for GET requests to route for group
+// g. See Server.WebSocket.
+func (g *Group) WebSocket(route string, handler interface{}) *Route {
+	return g.addRoute(route, "GET", webSocketHandler{handler})
+}
+
+// WebSocketConfig replaces ServerConfig.WebSocket for the WebSocket route r.
+func (r *Route) WebSocketConfig(config WebSocketConfig) *Route {
+	r.websocketConfig = &config
+	return r
+}
+
+func (s *Server) newWebSocketRoute(pathRegex string, cr *regexp.Regexp, method string, h webSocketHandler) (*Route, error) {
+	route, err := s.newRouteFromValue(pathRegex, cr, method, reflect.ValueOf(h.handler))
+	if err != nil {
+		return nil, err
+	}
+	if route.writeResult != nil {
+		return nil, errors.New("WebSocket handlers can not return values")
+	}
+	route.websocket = true
+	return route, nil
+}
+
+func (s *Server) webSocketConfig(r *Route) *WebSocketConfig {
+	if r.websocketConfig != nil {
+		return r.websocketConfig
+	}
+	if s.Config != nil && s.Config.WebSocket != nil {
+		return s.Config.WebSocket
+	}
+	return &WebSocketConfig{}
+}
+
+// serveWebSocket upgrades the connection and calls the handler of route.
+func (s *Server) serveWebSocket(ctx *Context, route *Route, match []string) {
+	config := s.webSocketConfig(route)
+	if !strings.EqualFold(ctx.Request.Header.Get("Upgrade"), "websocket") {
+		ctx.Error(400, "Bad Request - WebSocket upgrade required")
+		return
+	}
+	if !config.allowOrigin(ctx) {
+		ctx.Error(403, "Forbidden - cross-origin WebSocket")
+		return
+	}
+	if _, ok := ctx.ResponseWriter.(http.Hijacker); !ok {
+		ctx.Error(500, "WebSocket upgrade not supported")
+		return
+	}
+
+	tracker := &readTracker{}
+	server := websocket.Server{
+		Handshake: func(wsConfig *websocket.Config, req *http.Request) error {
+			wsConfig.Protocol = config.selectSubprotocol(wsConfig.Protocol)
+			return nil
+		},
+		Handler: func(conn *websocket.Conn) {
+			conn.MaxPayloadBytes = config.MaxMessageSize
+			if conn.MaxPayloadBytes == 0 {
+				conn.MaxPayloadBytes = DefaultMaxMessageSize
+			}
+			ctx.websocket = conn
+			stop := config.keepAlive(conn, tracker)
+			defer stop()
+			s.callHandler(ctx, route, match)
+		},
+	}
+	server.ServeHTTP(trackingHijacker{ctx.ResponseWriter, tracker}, ctx.Request)
+}
+
+// A readTracker records when data was last read from a hijacked
+// connection, which includes the pongs that websocket.Conn discards.
+type readTracker struct {
+	net.Conn
+	// lastRead is the time of the last read in unix nanoseconds
+	lastRead int64
+}
+
+func (t *readTracker) Read(b []byte) (int, error) {
+	n, err := t.Conn.Read(b)
+	if n > 0 {
+		atomic.StoreInt64(&t.lastRead, time.Now().UnixNano())
+	}
+	return n, err
+}
+
+func (t *readTracker) readSince(since time.Time) bool {
+	return atomic.LoadInt64(&t.lastRead) >= since.UnixNano()
+}
+
+// trackingHijacker hijacks the connection of a response through a
+// readTracker.
+type trackingHijacker struct {
+	http.ResponseWriter
+	tracker *readTracker
+}
+
+func (h trackingHijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := h.ResponseWriter.(http.Hijacker).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	h.tracker.Conn = conn
+	atomic.StoreInt64(&h.tracker.lastRead, time.Now().UnixNano())
+	// the data the server has already buffered is read first
+	buffered, _ := rw.Reader.Peek(rw.Reader.Buffered())
+	reader := io.MultiReader(bytes.NewReader(buffered), h.tracker)
+	return h.tracker, bufio.NewReadWriter(bufio.NewReader(reader), rw.Writer), nil
+}
+
+// allowOrigin reports whether a browser on the request's Origin may
+// connect.
+func (config *WebSocketConfig) allowOrigin(ctx *Context) bool {
+	origin := ctx.Request.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if strings.EqualFold(origin, ctx.Scheme()+"://"+ctx.Host()) {
+		return true
+	}
+	for _, pattern := range config.AllowedOrigins {
+		if matchWildcard(pattern, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// selectSubprotocol returns the preferred subprotocol among the ones offered
+// by the client, or nil if none is supported.
+func (config *WebSocketConfig) selectSubprotocol(offered []string) []string {
+	for _, protocol := range config.Subprotocols {
+		for _, o := range offered {
+			if o == protocol {
+				return []string{protocol}
+			}
+		}
+	}
+	return nil
+}
+
+// pingCodec sends an empty ping frame. Unlike a frame writer, a Codec holds
+// the write lock of the connection, so pings do not interleave with the
+// handler's messages.
+var pingCodec = websocket.Codec{
+	Marshal: func(v interface{}) ([]byte, byte, error) {
+		return nil, websocket.PingFrame, nil
+	},
+}
+
+// keepAlive pings conn until the returned function is called. If nothing
+// was read since the previous ping, the underlying connection is closed,
+// which also ends blocked reads and writes of the handler. The deadlines
+// of the connection are left to the handler.
+func (config *WebSocketConfig) keepAlive(conn *websocket.Conn, tracker *readTracker) func() {
+	interval := config.PingInterval
+	if interval == 0 {
+		interval = DefaultPingInterval
+	}
+	if interval < 0 {
+		return func() {}
+	}
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		var lastPing time.Time
+		var pinging int32
+		for {
+			select {
+			case now := <-ticker.C:
+				if !lastPing.IsZero() && !tracker.readSince(lastPing) {
+					tracker.Close()
+					return
+				}
+				lastPing = now
+				// a ping to a dead peer may block, which must not keep the
+				// connection from being closed
+				if atomic.CompareAndSwapInt32(&pinging, 0, 1) {
+					go func() {
+						pingCodec.Send(conn, nil)
+						atomic.StoreInt32(&pinging, 0)
+					}()
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-stopped
+	}
+}
+
+func getWebSocketConn(t reflect.Type, values []string, valueIndex int, ctx *Context) (reflect.Value, error) {
+	if t != webSocketConnType {
+		return reflect.Value{}, NotSupported
+	}
+	if ctx == nil || ctx.websocket == nil {
+		return reflect.Zero(t), NoValueNeeded
+	}
+	return reflect.ValueOf(ctx.websocket), NoValueNeeded
+}
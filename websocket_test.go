@@ -0,0 +1,165 @@
+package web
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+func dialWebSocket(t *testing.T, server *httptest.Server, path string, protocols ...string) *websocket.Conn {
+	config, err := websocket.NewConfig("ws"+strings.TrimPrefix(server.URL, "http")+path, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Protocol = protocols
+	config.Header = http.Header{"Cookie": {"user=alice"}}
+	conn, err := websocket.DialConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func TestWebSocket(t *testing.T) {
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	s.Config = &ServerConfig{
+		// the handler timeout does not apply to WebSocket routes
+		HandlerTimeout: 10 * time.Millisecond,
+		WebSocket:      &WebSocketConfig{Subprotocols: []string{"v2", "v1"}, PingInterval: 20 * time.Millisecond},
+	}
+	s.WebSocket("/echo/(\\w+)", func(ctx *Context, conn *websocket.Conn, room string) {
+		user, _ := ctx.Request.Cookie("user")
+		var msg string
+		for websocket.Message.Receive(conn, &msg) == nil {
+			websocket.Message.Send(conn, room+"/"+user.Value+"/"+conn.Config().Protocol[0]+": "+msg)
+		}
+	})
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	conn := dialWebSocket(t, server, "/echo/lobby", "v1", "v2")
+	defer conn.Close()
+	replies := make(chan string)
+	go func() {
+		var reply string
+		for websocket.Message.Receive(conn, &reply) == nil {
+			replies <- reply
+		}
+		close(replies)
+	}()
+	// while both sides read, the pings of several intervals are answered
+	for _, msg := range []string{"hi", "again"} {
+		if err := websocket.Message.Send(conn, msg); err != nil {
+			t.Fatal(err)
+		}
+		if reply := <-replies; reply != "lobby/alice/v2: "+msg {
+			t.Fatalf("Expected reply %q, got %q", "lobby/alice/v2: "+msg, reply)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if protocol := conn.Config().Protocol; len(protocol) != 1 || protocol[0] != "v2" {
+		t.Fatalf("Expected subprotocol v2, got %v", protocol)
+	}
+}
+
+func TestWebSocketDeadPeer(t *testing.T) {
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	closed := make(chan error, 1)
+	s.WebSocket("/ws", func(conn *websocket.Conn) {
+		var msg string
+		closed <- websocket.Message.Receive(conn, &msg)
+	}).WebSocketConfig(WebSocketConfig{PingInterval: 5 * time.Millisecond})
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	// a client that never reads does not answer the pings
+	conn := dialWebSocket(t, server, "/ws")
+	defer conn.Close()
+	select {
+	case err := <-closed:
+		if err == nil {
+			t.Fatalf("Expected the connection to fail")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected the connection of an unresponsive peer to be closed")
+	}
+}
+
+func TestWebSocketMessageSize(t *testing.T) {
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	received := make(chan error, 1)
+	s.WebSocket("/ws", func(conn *websocket.Conn) {
+		var msg []byte
+		received <- websocket.Message.Receive(conn, &msg)
+	}).WebSocketConfig(WebSocketConfig{MaxMessageSize: 8})
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	conn := dialWebSocket(t, server, "/ws")
+	defer conn.Close()
+	websocket.Message.Send(conn, strings.Repeat("x", 16))
+	if err := <-received; err != websocket.ErrFrameTooLarge {
+		t.Fatalf("Expected ErrFrameTooLarge, got %v", err)
+	}
+}
+
+func TestWebSocketRejected(t *testing.T) {
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	s.Config = &ServerConfig{WebSocket: &WebSocketConfig{AllowedOrigins: []string{"https://*.example.com"}}}
+	s.WebSocket("/ws", func(conn *websocket.Conn) {})
+	s.WebSocket("/private", func(conn *websocket.Conn) {}).RequireAuth()
+
+	tests := []struct {
+		path    string
+		upgrade bool
+		origin  string
+		status  int
+	}{
+		{"/ws", false, "", 400},
+		{"/ws", true, "https://evil.com", 403},
+		{"/private", true, "", 401},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", test.path, nil)
+		if test.upgrade {
+			req.Header.Set("Upgrade", "websocket")
+		}
+		if test.origin != "" {
+			req.Header.Set("Origin", test.origin)
+		}
+		rec := httptest.NewRecorder()
+		s.Process(rec, req)
+		if rec.Code != test.status {
+			t.Errorf("%s with origin %q: expected %d, got %d", test.path, test.origin, test.status, rec.Code)
+		}
+	}
+
+	allowed := []string{"", "http://example.com", "https://app.example.com"}
+	for _, origin := range allowed {
+		req := httptest.NewRequest("GET", "http://example.com/ws", nil)
+		req.Header.Set("Origin", origin)
+		if !s.webSocketConfig(s.routes[0]).allowOrigin(&Context{Request: req, Server: s}) {
+			t.Errorf("Expected origin %q to be allowed", origin)
+		}
+	}
+}
+
+func TestWebSocketHandlerResults(t *testing.T) {
+	defer func() {
+		if err := recover(); !strings.Contains(fmt.Sprint(err), "can not return values") {
+			t.Fatalf("Expected the handler to be rejected, got %v", err)
+		}
+	}()
+	NewServer().WebSocket("/ws", func(conn *websocket.Conn) string { return "" })
+}